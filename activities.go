@@ -6,35 +6,34 @@ import (
 	"log"
 	"time"
 
+	"github.com/MrException/invtrak/broker"
 	"github.com/boltdb/bolt"
 )
 
-type Activity struct {
-	ID              int     `json:"id"`
-	TradeDate       string  `json:"tradeDate"`
-	TransactionDate string  `json:"transactionDate"`
-	SettlementDate  string  `json:"settlementDate"`
-	Action          string  `json:"action"`
-	Symbol          string  `json:"symbol"`
-	SymbolID        int     `json:"symbolId"`
-	Description     string  `json:"description"`
-	Currency        string  `json:"currency"`
-	Quantity        int     `json:"quantity"`
-	Price           float64 `json:"price"`
-	GrossAmount     float64 `json:"grossAmount"`
-	Commission      float64 `json:"commission"`
-	NetAmount       float64 `json:"netAmount"`
-	Type            string  `json:"type"`
-}
-
-func (a Activity) String() string {
-	return prettyJSON(a)
-}
-
-type ActivitiesReq struct {
-	Activities []Activity `json:"activities"`
+// defaultHistory bounds how far back a first-time sync walks when no
+// --history override is given - about 2.25 years, matching the window the
+// old unconditional refresh used to cover.
+const defaultHistory = time.Duration(821) * 24 * time.Hour
+
+// activityChunk is the size of each request window when walking a date
+// range; Questrade activity queries are documented to cover at most 31 days.
+const activityChunk = 31 * 24 * time.Hour
+
+// activityOverlap is re-fetched on every incremental sync so activities that
+// settle a few days after their transaction date aren't missed.
+const activityOverlap = 3 * 24 * time.Hour
+
+// syncWatermark tracks how far an account's activity sync has progressed, so
+// a refresh only has to fetch the delta since the last run instead of
+// re-walking the whole history window every time.
+type syncWatermark struct {
+	EarliestStart time.Time `json:"earliestStart"`
+	LastEnd       time.Time `json:"lastEnd"`
 }
 
+// refreshAllActivities refreshes every stored account that belongs to the
+// broker the CLI was invoked with (--broker), skipping the rest so a single
+// invocation never dispatches to a Broker other than the one it authenticated.
 func refreshAllActivities() error {
 	accounts, err := loadAccounts()
 	if err != nil {
@@ -42,8 +41,11 @@ func refreshAllActivities() error {
 	}
 
 	for _, account := range accounts {
-		err = refreshActivities(account.Number)
-		if err != nil {
+		if account.BrokerType != conf.broker {
+			log.Printf("Skipping account %s: belongs to broker %q, not %q.", account.Number, account.BrokerType, conf.broker)
+			continue
+		}
+		if err := refreshActivities(account.Number); err != nil {
 			return err
 		}
 	}
@@ -53,65 +55,136 @@ func refreshAllActivities() error {
 }
 
 func refreshActivities(accountID string) error {
-	return requestActivities(accountID)
-}
+	account, err := findAccount(accountID)
+	if err != nil {
+		return err
+	}
+	if account.BrokerType != conf.broker {
+		return fmt.Errorf("account %s belongs to broker %q, not %q - pass the matching --broker", accountID, account.BrokerType, conf.broker)
+	}
 
-func requestActivities(accountID string) error {
-	log.Printf("Requesting Activities.")
-	// start with the most recent 30 days
-	startDate := time.Now().AddDate(0, 0, -30)
-	endDate := time.Now()
+	if conf.fullResync {
+		return fullResyncActivities(accountID)
+	}
 
-	days := (365 * 2.5) / 30 // number of 30 day blocks in 2 1/2 years - go back to fall 2015
-	for i := 0; i <= int(days); i++ {
-		url := fmt.Sprintf("%sv1/accounts/%s/activities?startTime=%s&endTime=%s", token.APIServer, accountID, startDate.Format(time.RFC3339), endDate.Format(time.RFC3339))
-		res, err := doReq(url, true)
-		if err != nil {
-			return fmt.Errorf("error requesting accounts, %v", err)
+	watermark, err := loadWatermark(accountID)
+	if err != nil {
+		return err
+	}
+	if watermark == nil {
+		return fullResyncActivities(accountID)
+	}
+
+	return incrementalSyncActivities(accountID, watermark)
+}
+
+// fullResyncActivities walks backward in activityChunk-sized windows from
+// now until conf.history, the old unconditional refresh behavior. It's used
+// both for --full-resync and for an account's very first sync.
+func fullResyncActivities(accountID string) error {
+	log.Printf("Doing a full activity resync for account %s back to %s.", accountID, conf.history)
+	now := time.Now()
+	cutoff := now.Add(-conf.history)
+
+	for end := now; end.After(cutoff); end = end.Add(-activityChunk) {
+		start := end.Add(-activityChunk)
+		if start.Before(cutoff) {
+			start = cutoff
 		}
-		_, err = saveActivities(res, accountID)
-		if err != nil {
-			return fmt.Errorf("error saving activities, %v", err)
+		if err := syncActivityWindow(accountID, start, end); err != nil {
+			return err
 		}
-		// log.Printf("Response: %s\n", string(res))
+	}
+
+	return saveWatermark(accountID, &syncWatermark{EarliestStart: cutoff, LastEnd: now})
+}
 
-		startDate = startDate.AddDate(0, 0, -31)
-		endDate = endDate.AddDate(0, 0, -31)
+// incrementalSyncActivities fetches only the activities since the last
+// successful sync, plus a small overlap window to catch late settlements.
+func incrementalSyncActivities(accountID string, watermark *syncWatermark) error {
+	now := time.Now()
+	start := watermark.LastEnd.Add(-activityOverlap)
+
+	for cursor := start; cursor.Before(now); cursor = cursor.Add(activityChunk) {
+		end := cursor.Add(activityChunk)
+		if end.After(now) {
+			end = now
+		}
+		if err := syncActivityWindow(accountID, cursor, end); err != nil {
+			return err
+		}
 	}
 
-	// log.Printf("%+v\n", accounts)
+	watermark.LastEnd = now
+	return saveWatermark(accountID, watermark)
+}
 
+func syncActivityWindow(accountID string, start, end time.Time) error {
+	log.Printf("Requesting activities for account %s from %s to %s.", accountID, start, end)
+	activities, err := activeBroker.FetchActivities(accountID, start, end)
+	if err != nil {
+		return fmt.Errorf("error requesting activities, %v", err)
+	}
+	if _, err := saveActivities(activities, accountID); err != nil {
+		return fmt.Errorf("error saving activities, %v", err)
+	}
 	return nil
 }
 
-func saveActivities(body []byte, accountID string) ([]Activity, error) {
-	log.Println("Saving Activities.")
-
-	activities := &ActivitiesReq{}
-	err := json.Unmarshal(body, activities)
+func loadWatermark(accountID string) (*syncWatermark, error) {
+	var watermark *syncWatermark
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("SYNC"))
+		if bk == nil {
+			return nil
+		}
+		v := bk.Get([]byte(accountID))
+		if v == nil {
+			return nil
+		}
+		watermark = &syncWatermark{}
+		return json.Unmarshal(v, watermark)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %s", err)
+		return nil, fmt.Errorf("could not load sync watermark, %v", err)
 	}
+	return watermark, nil
+}
 
+func saveWatermark(accountID string, watermark *syncWatermark) error {
+	watermarkBytes, err := json.Marshal(watermark)
+	if err != nil {
+		return fmt.Errorf("could not marshal sync watermark: %v", err)
+	}
 	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("SYNC")).Put([]byte(accountID), watermarkBytes)
+	})
+	if err != nil {
+		return fmt.Errorf("could not save sync watermark, %v", err)
+	}
+	return nil
+}
+
+func saveActivities(activities []broker.Activity, accountID string) ([]broker.Activity, error) {
+	log.Println("Saving Activities.")
+
+	err := db.Update(func(tx *bolt.Tx) error {
 		bkName := fmt.Sprintf("ACTIVITIES-%s", accountID)
 		bk, err := tx.CreateBucketIfNotExists([]byte(bkName))
 		if err != nil {
 			return fmt.Errorf("couldn't get/create %s bucket, %v", bkName, err)
 		}
 
-		for _, activity := range activities.Activities {
-			// log.Printf("JSON: %s", prettyJSON(activity))
-			seq, err := bk.NextSequence()
-			if err != nil {
-				return fmt.Errorf("could not get next sequence from bucket")
-			}
-			activity.ID = int(seq)
+		for _, activity := range activities {
 			activityBytes, err := json.Marshal(activity)
 			if err != nil {
 				return fmt.Errorf("could not marshal entry json: %v", err)
 			}
-			err = bk.Put(itob(activity.ID), activityBytes)
+			// Keyed on a stable composite of the activity's own fields
+			// (not an auto-incrementing sequence) so re-ingesting an
+			// overlapping window overwrites the same record instead of
+			// duplicating it.
+			err = bk.Put(activityKey(accountID, activity), activityBytes)
 			if err != nil {
 				return fmt.Errorf("could not insert activity: %v", err)
 			}
@@ -123,12 +196,19 @@ func saveActivities(body []byte, accountID string) ([]Activity, error) {
 	if err != nil {
 		return nil, fmt.Errorf("could not save activities, %v", err)
 	}
-	return activities.Activities, nil
+	return activities, nil
+}
+
+// activityKey builds a stable composite key for an activity so replaying an
+// overlapping fetch window overwrites the existing record instead of
+// duplicating it.
+func activityKey(accountID string, a broker.Activity) []byte {
+	return []byte(fmt.Sprintf("%s|%s|%d|%s|%d|%.2f", a.TransactionDate, accountID, a.SymbolID, a.Action, a.Quantity, a.NetAmount))
 }
 
-func loadActivities(accountID string, tradeType string) ([]Activity, error) {
+func loadActivities(accountID string, tradeType string) ([]broker.Activity, error) {
 	log.Println("Loading Activities.")
-	activities := make([]Activity, 0)
+	activities := make([]broker.Activity, 0)
 	err := db.View(func(tx *bolt.Tx) error {
 		bkName := fmt.Sprintf("ACTIVITIES-%s", accountID)
 		bk := tx.Bucket([]byte(bkName))
@@ -138,7 +218,7 @@ func loadActivities(accountID string, tradeType string) ([]Activity, error) {
 
 		c := bk.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			act := &Activity{}
+			act := &broker.Activity{}
 			err := json.Unmarshal(v, act)
 			if err != nil {
 				return fmt.Errorf("could not unmarshal activity: %v", err)
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MrException/invtrak/broker"
+)
+
+func TestActivityKeyStableForIdenticalActivity(t *testing.T) {
+	a := broker.Activity{TransactionDate: "2024-01-10T00:00:00Z", SymbolID: 1, Action: "Buy", Quantity: 100, NetAmount: -1000.5}
+
+	if string(activityKey("A1", a)) != string(activityKey("A1", a)) {
+		t.Error("expected the same activity to always produce the same key")
+	}
+}
+
+func TestActivityKeyDistinguishesOverlappingActivities(t *testing.T) {
+	base := broker.Activity{TransactionDate: "2024-01-10T00:00:00Z", SymbolID: 1, Action: "Buy", Quantity: 100, NetAmount: -1000.5}
+
+	variants := []broker.Activity{
+		{TransactionDate: "2024-01-11T00:00:00Z", SymbolID: base.SymbolID, Action: base.Action, Quantity: base.Quantity, NetAmount: base.NetAmount},
+		{TransactionDate: base.TransactionDate, SymbolID: 2, Action: base.Action, Quantity: base.Quantity, NetAmount: base.NetAmount},
+		{TransactionDate: base.TransactionDate, SymbolID: base.SymbolID, Action: "Sell", Quantity: base.Quantity, NetAmount: base.NetAmount},
+		{TransactionDate: base.TransactionDate, SymbolID: base.SymbolID, Action: base.Action, Quantity: 50, NetAmount: base.NetAmount},
+		{TransactionDate: base.TransactionDate, SymbolID: base.SymbolID, Action: base.Action, Quantity: base.Quantity, NetAmount: -2000.5},
+	}
+
+	baseKey := string(activityKey("A1", base))
+	for i, v := range variants {
+		if string(activityKey("A1", v)) == baseKey {
+			t.Errorf("variant %d should have produced a different key than the base activity", i)
+		}
+	}
+
+	if string(activityKey("A1", base)) == string(activityKey("A2", base)) {
+		t.Error("expected the same activity under different accounts to produce different keys")
+	}
+}
@@ -0,0 +1,66 @@
+// Package broker defines the interface each supported brokerage/import
+// adapter implements, plus the shared account/activity/candle types they all
+// deal in. The CLI talks to whichever adapter the user selected (Questrade,
+// a CSV import, ...) only through this interface.
+package broker
+
+import "time"
+
+// Account is a brokerage account as reported by a Broker implementation.
+// BrokerType records which adapter discovered it, so a later refresh knows
+// which Broker to dispatch through.
+type Account struct {
+	Type              string `json:"type"`
+	Number            string `json:"number"`
+	Status            string `json:"status"`
+	IsPrimary         bool   `json:"isPrimary"`
+	IsBilling         bool   `json:"isBilling"`
+	ClientAccountType string `json:"clientAccountType"`
+	BrokerType        string `json:"brokerType"`
+}
+
+// Activity is a single account activity (trade, dividend, transfer, ...).
+type Activity struct {
+	TradeDate       string  `json:"tradeDate"`
+	TransactionDate string  `json:"transactionDate"`
+	SettlementDate  string  `json:"settlementDate"`
+	Action          string  `json:"action"`
+	Symbol          string  `json:"symbol"`
+	SymbolID        int     `json:"symbolId"`
+	Description     string  `json:"description"`
+	Currency        string  `json:"currency"`
+	Quantity        int     `json:"quantity"`
+	Price           float64 `json:"price"`
+	GrossAmount     float64 `json:"grossAmount"`
+	Commission      float64 `json:"commission"`
+	NetAmount       float64 `json:"netAmount"`
+	Type            string  `json:"type"`
+}
+
+// Candle is a single OHLC bar.
+type Candle struct {
+	Start  string  `json:"start"`
+	End    string  `json:"end"`
+	Low    float64 `json:"low"`
+	High   float64 `json:"high"`
+	Open   float64 `json:"open"`
+	Close  float64 `json:"close"`
+	Volume int     `json:"volume"`
+}
+
+// Candles is a series of Candle, matching the shape Questrade's API returns.
+type Candles struct {
+	Candles []Candle `json:"candles"`
+}
+
+// Broker is implemented by each supported brokerage or import adapter.
+// Authenticate is called once before any other method; adapters that don't
+// need auth (csv) can make it a no-op. FetchCandles may return an error for
+// adapters with no market-data access (csv) - callers that need candles
+// should treat that as "unsupported", not a hard failure.
+type Broker interface {
+	Authenticate() error
+	ListAccounts() ([]Account, error)
+	FetchActivities(accountID string, start, end time.Time) ([]Activity, error)
+	FetchCandles(symbolID, interval string, start, end time.Time) (*Candles, error)
+}
@@ -0,0 +1,243 @@
+// Package csv is a broker.Broker adapter for brokerages that don't offer an
+// API - it reads activities out of a plain CSV export instead. Which columns
+// map to which Activity fields is described by a Config the user writes
+// once per export format (Interactive Brokers, Wealthsimple, etc.).
+package csv
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MrException/invtrak/broker"
+	"gopkg.in/yaml.v2"
+)
+
+// ColumnMapping names the CSV header for each Activity field this adapter
+// knows how to populate. A field left blank is simply left at its zero
+// value - not every export carries every field. TransactionDate is the
+// exception: every row must parse under the configured date layout, or the
+// whole read fails.
+type ColumnMapping struct {
+	TradeDate       string `json:"tradeDate" yaml:"tradeDate"`
+	TransactionDate string `json:"transactionDate" yaml:"transactionDate"`
+	SettlementDate  string `json:"settlementDate" yaml:"settlementDate"`
+	Action          string `json:"action" yaml:"action"`
+	Symbol          string `json:"symbol" yaml:"symbol"`
+	// SymbolID is optional - most CSV exports don't carry a brokerage symbol
+	// ID at all. Left blank (the common case), a stable ID is synthesized
+	// from Symbol instead; see syntheticSymbolID.
+	SymbolID    string `json:"symbolId" yaml:"symbolId"`
+	Description string `json:"description" yaml:"description"`
+	Currency    string `json:"currency" yaml:"currency"`
+	Quantity    string `json:"quantity" yaml:"quantity"`
+	Price       string `json:"price" yaml:"price"`
+	GrossAmount string `json:"grossAmount" yaml:"grossAmount"`
+	Commission  string `json:"commission" yaml:"commission"`
+	NetAmount   string `json:"netAmount" yaml:"netAmount"`
+	Type        string `json:"type" yaml:"type"`
+}
+
+// Config is a schema file describing one CSV export: which account it
+// belongs to, where the file lives, and how its columns map onto Activity
+// fields. Config files are YAML or JSON, chosen by the file's extension.
+type Config struct {
+	AccountNumber string        `json:"accountNumber" yaml:"accountNumber"`
+	CSVPath       string        `json:"csvPath" yaml:"csvPath"`
+	DateLayout    string        `json:"dateLayout" yaml:"dateLayout"`
+	Columns       ColumnMapping `json:"columns" yaml:"columns"`
+}
+
+// defaultDateLayout is used when a Config doesn't specify one.
+const defaultDateLayout = "2006-01-02"
+
+// LoadConfig reads a single schema file and returns its Config. Files named
+// *.json are parsed as JSON; everything else is parsed as YAML.
+func LoadConfig(path string) (Config, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("csv: could not read config %s: %v", path, err)
+	}
+
+	cfg := Config{}
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(body, &cfg)
+	} else {
+		err = yaml.Unmarshal(body, &cfg)
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("csv: could not parse config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Broker reads activities out of one or more CSV exports, each described by
+// a Config, in place of calling a live brokerage API. It has no accounts or
+// market data beyond what the Configs describe.
+type Broker struct {
+	configs []Config
+}
+
+var _ broker.Broker = (*Broker)(nil)
+
+// New builds a Broker from already-loaded Configs, one per account.
+func New(configs ...Config) *Broker {
+	return &Broker{configs: configs}
+}
+
+// Authenticate is a no-op - CSV imports read local files and need no auth.
+func (b *Broker) Authenticate() error {
+	return nil
+}
+
+// ListAccounts returns one broker.Account per configured CSV export.
+func (b *Broker) ListAccounts() ([]broker.Account, error) {
+	accounts := make([]broker.Account, 0, len(b.configs))
+	for _, cfg := range b.configs {
+		accounts = append(accounts, broker.Account{
+			Number:     cfg.AccountNumber,
+			Type:       "CSV Import",
+			Status:     "Active",
+			BrokerType: "csv",
+		})
+	}
+	return accounts, nil
+}
+
+// FetchActivities reads accountID's configured CSV export and returns the
+// rows whose transaction date falls within [start, end].
+func (b *Broker) FetchActivities(accountID string, start, end time.Time) ([]broker.Activity, error) {
+	cfg, ok := b.configFor(accountID)
+	if !ok {
+		return nil, fmt.Errorf("csv: no config for account %s", accountID)
+	}
+	return readActivities(cfg, start, end)
+}
+
+// FetchCandles is unsupported - CSV exports carry no market data.
+func (b *Broker) FetchCandles(symbolID, interval string, start, end time.Time) (*broker.Candles, error) {
+	return nil, fmt.Errorf("csv: broker does not support candles")
+}
+
+func (b *Broker) configFor(accountID string) (Config, bool) {
+	for _, cfg := range b.configs {
+		if cfg.AccountNumber == accountID {
+			return cfg, true
+		}
+	}
+	return Config{}, false
+}
+
+func readActivities(cfg Config, start, end time.Time) ([]broker.Activity, error) {
+	f, err := os.Open(cfg.CSVPath)
+	if err != nil {
+		return nil, fmt.Errorf("csv: could not open export %s: %v", cfg.CSVPath, err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv: could not read header of %s: %v", cfg.CSVPath, err)
+	}
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[name] = i
+	}
+
+	dateLayout := cfg.DateLayout
+	if dateLayout == "" {
+		dateLayout = defaultDateLayout
+	}
+
+	activities := make([]broker.Activity, 0)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("csv: could not read row of %s: %v", cfg.CSVPath, err)
+		}
+
+		activity, transactionDate, err := mapRow(cfg.Columns, index, row, dateLayout)
+		if err != nil {
+			return nil, err
+		}
+		if transactionDate.Before(start) || transactionDate.After(end) {
+			continue
+		}
+		activities = append(activities, activity)
+	}
+	return activities, nil
+}
+
+func mapRow(cols ColumnMapping, index map[string]int, row []string, dateLayout string) (broker.Activity, time.Time, error) {
+	field := func(name string) string {
+		if name == "" {
+			return ""
+		}
+		i, ok := index[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return row[i]
+	}
+
+	transactionDate, err := time.Parse(dateLayout, field(cols.TransactionDate))
+	if err != nil {
+		return broker.Activity{}, time.Time{}, fmt.Errorf("csv: could not parse transaction date %q: %v", field(cols.TransactionDate), err)
+	}
+
+	symbol := field(cols.Symbol)
+	symbolID := parseIntField(field(cols.SymbolID))
+	if symbolID == 0 {
+		symbolID = syntheticSymbolID(symbol)
+	}
+
+	activity := broker.Activity{
+		TradeDate:       field(cols.TradeDate),
+		TransactionDate: transactionDate.Format(time.RFC3339),
+		SettlementDate:  field(cols.SettlementDate),
+		Action:          field(cols.Action),
+		Symbol:          symbol,
+		SymbolID:        symbolID,
+		Description:     field(cols.Description),
+		Currency:        field(cols.Currency),
+		Quantity:        parseIntField(field(cols.Quantity)),
+		Price:           parseFloatField(field(cols.Price)),
+		GrossAmount:     parseFloatField(field(cols.GrossAmount)),
+		Commission:      parseFloatField(field(cols.Commission)),
+		NetAmount:       parseFloatField(field(cols.NetAmount)),
+		Type:            field(cols.Type),
+	}
+	return activity, transactionDate, nil
+}
+
+// syntheticSymbolID gives a CSV row a stable, non-zero per-symbol int ID
+// when no SymbolID column is configured. portfolio.bookFor and activityKey
+// both key on Activity.SymbolID rather than the symbol string, so without
+// this every row would share the zero value and distinct symbols would be
+// merged into one book (and collide in the dedup key).
+func syntheticSymbolID(symbol string) int {
+	h := fnv.New32a()
+	h.Write([]byte(symbol))
+	return int(h.Sum32() & 0x7fffffff)
+}
+
+func parseIntField(s string) int {
+	v, _ := strconv.Atoi(s)
+	return v
+}
+
+func parseFloatField(s string) float64 {
+	v, _ := strconv.ParseFloat(s, 64)
+	return v
+}
@@ -0,0 +1,81 @@
+package csv
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func writeTempCSV(t *testing.T, contents string) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "export-*.csv")
+	if err != nil {
+		t.Fatalf("could not create temp csv: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatalf("could not write temp csv: %v", err)
+	}
+	return f.Name()
+}
+
+func TestReadActivitiesSynthesizesDistinctSymbolIDs(t *testing.T) {
+	path := writeTempCSV(t, "date,symbol,action,qty,net\n"+
+		"2024-01-10,AAPL,Buy,10,-1000\n"+
+		"2024-01-10,MSFT,Buy,5,-750\n")
+
+	cfg := Config{
+		CSVPath: path,
+		Columns: ColumnMapping{
+			TransactionDate: "date",
+			Symbol:          "symbol",
+			Action:          "action",
+			Quantity:        "qty",
+			NetAmount:       "net",
+		},
+	}
+
+	activities, err := readActivities(cfg, time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("readActivities: %v", err)
+	}
+	if len(activities) != 2 {
+		t.Fatalf("expected 2 activities, got %d", len(activities))
+	}
+
+	aapl, msft := activities[0], activities[1]
+	if aapl.SymbolID == 0 || msft.SymbolID == 0 {
+		t.Fatalf("expected non-zero synthesized SymbolIDs, got %d and %d", aapl.SymbolID, msft.SymbolID)
+	}
+	if aapl.SymbolID == msft.SymbolID {
+		t.Fatalf("expected AAPL and MSFT to get distinct SymbolIDs, both got %d", aapl.SymbolID)
+	}
+}
+
+func TestReadActivitiesHonorsConfiguredSymbolIDColumn(t *testing.T) {
+	path := writeTempCSV(t, "date,symbol,symbolId,action,qty,net\n"+
+		"2024-01-10,AAPL,8049,Buy,10,-1000\n")
+
+	cfg := Config{
+		CSVPath: path,
+		Columns: ColumnMapping{
+			TransactionDate: "date",
+			Symbol:          "symbol",
+			SymbolID:        "symbolId",
+			Action:          "action",
+			Quantity:        "qty",
+			NetAmount:       "net",
+		},
+	}
+
+	activities, err := readActivities(cfg, time.Time{}, time.Now())
+	if err != nil {
+		t.Fatalf("readActivities: %v", err)
+	}
+	if len(activities) != 1 {
+		t.Fatalf("expected 1 activity, got %d", len(activities))
+	}
+	if activities[0].SymbolID != 8049 {
+		t.Errorf("expected the configured SymbolID column to be used, got %d", activities[0].SymbolID)
+	}
+}
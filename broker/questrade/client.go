@@ -0,0 +1,279 @@
+// Package questrade wraps the Questrade REST API: token lifecycle, rate
+// limiting and typed errors, so that callers (CLI, future web UI, analytics)
+// can treat it as a plain Go client instead of hand-rolling HTTP calls.
+// Client implements broker.Broker, so it can be used interchangeably with
+// other brokerage adapters.
+package questrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MrException/invtrak/broker"
+)
+
+const authServer = "https://login.questrade.com/"
+
+// maxRetries bounds the exponential backoff applied to 429 responses that
+// don't carry a usable Retry-After header.
+const maxRetries = 5
+
+// Token is the OAuth2 token Questrade issues, plus the time we fetched it so
+// we can tell when it needs refreshing without re-requesting on every call.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	ExpiresIn    int       `json:"expires_in"`
+	RefreshToken string    `json:"refresh_token"`
+	APIServer    string    `json:"api_server"`
+	IssuedAt     time.Time `json:"issued_at"`
+}
+
+func (t Token) expired() bool {
+	// Refresh a little early so a call doesn't race the expiry.
+	return time.Now().After(t.IssuedAt.Add(time.Duration(t.ExpiresIn)*time.Second - 30*time.Second))
+}
+
+// TokenStore persists the current token between runs so the client only
+// refreshes it when it's actually close to expiring.
+type TokenStore interface {
+	LoadToken() (*Token, error)
+	SaveToken(*Token) error
+}
+
+type accountsResponse struct {
+	Accounts []broker.Account `json:"accounts"`
+	UserID   int              `json:"userId"`
+}
+
+type activitiesResponse struct {
+	Activities []broker.Activity `json:"activities"`
+}
+
+// Client is a Questrade API client. It owns token refresh and rate limiting,
+// so callers just ask for accounts/activities/candles.
+type Client struct {
+	store        TokenStore
+	refreshToken string
+	token        *Token
+	httpClient   *http.Client
+	accountLimit *limiter
+	marketLimit  *limiter
+}
+
+var _ broker.Broker = (*Client)(nil)
+
+// NewClient builds a Client. If store already has a saved token it's reused
+// (and only refreshed once it's close to expiry); otherwise refreshToken is
+// used to mint the first one.
+func NewClient(store TokenStore, refreshToken string) (*Client, error) {
+	c := &Client{
+		store:        store,
+		refreshToken: refreshToken,
+		httpClient:   &http.Client{},
+		accountLimit: newAccountLimiter(),
+		marketLimit:  newMarketLimiter(),
+	}
+
+	if err := c.ensureToken(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Client) ensureToken() error {
+	if c.token == nil {
+		saved, err := c.store.LoadToken()
+		if err == nil {
+			c.token = saved
+		}
+	}
+
+	if c.token != nil && !c.token.expired() {
+		return nil
+	}
+
+	refreshToken := c.refreshToken
+	if c.token != nil {
+		refreshToken = c.token.RefreshToken
+	}
+
+	return c.refreshTokenNow(refreshToken)
+}
+
+func (c *Client) refreshTokenNow(refreshToken string) error {
+	url := fmt.Sprintf("%soauth2/token?grant_type=refresh_token&refresh_token=%s", authServer, refreshToken)
+
+	body, status, _, err := c.rawRequest(url, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return &AuthError{Err: fmt.Errorf("unexpected status %d refreshing token", status)}
+	}
+
+	token := &Token{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return &AuthError{Err: fmt.Errorf("error parsing token response: %v", err)}
+	}
+	token.IssuedAt = time.Now()
+
+	if err := c.store.SaveToken(token); err != nil {
+		return fmt.Errorf("questrade: error saving token: %v", err)
+	}
+
+	c.token = token
+	return nil
+}
+
+// Authenticate ensures the client holds a valid, unexpired token. ListAccounts,
+// FetchActivities and FetchCandles also do this internally, so calling it
+// up front is optional; it exists to satisfy broker.Broker and to let
+// callers fail fast on bad credentials before issuing any data calls.
+func (c *Client) Authenticate() error {
+	return c.ensureToken()
+}
+
+// ListAccounts returns the accounts accessible with the current token.
+func (c *Client) ListAccounts() ([]broker.Account, error) {
+	url := fmt.Sprintf("%sv1/accounts", c.token.APIServer)
+
+	resp := &accountsResponse{}
+	if err := c.get(c.accountLimit, url, resp); err != nil {
+		return nil, err
+	}
+	return resp.Accounts, nil
+}
+
+// FetchActivities returns activities for accountID between start and end.
+// Questrade limits a single request to a 31-day window, so callers fetching
+// longer ranges must chunk the request themselves.
+func (c *Client) FetchActivities(accountID string, start, end time.Time) ([]broker.Activity, error) {
+	url := fmt.Sprintf("%sv1/accounts/%s/activities?startTime=%s&endTime=%s",
+		c.token.APIServer, accountID, start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	resp := &activitiesResponse{}
+	if err := c.get(c.accountLimit, url, resp); err != nil {
+		return nil, err
+	}
+	return resp.Activities, nil
+}
+
+// FetchCandles returns OHLC candles for symbolID between start and end at
+// the given interval (one of Questrade's interval enum values, e.g.
+// "OneDay").
+func (c *Client) FetchCandles(symbolID string, interval string, start, end time.Time) (*broker.Candles, error) {
+	url := fmt.Sprintf("%sv1/markets/candles/%s?interval=%s&startTime=%s&endTime=%s",
+		c.token.APIServer, symbolID, interval, start.Format(time.RFC3339), end.Format(time.RFC3339))
+
+	resp := &broker.Candles{}
+	if err := c.get(c.marketLimit, url, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// get performs an authenticated, rate-limited GET and unmarshals the result
+// into v, refreshing the token once if the call comes back unauthorized.
+func (c *Client) get(l *limiter, url string, v interface{}) error {
+	if err := c.ensureToken(); err != nil {
+		return err
+	}
+
+	body, err := c.authedRequest(l, url)
+	if _, ok := err.(*AuthError); ok {
+		if rerr := c.refreshTokenNow(c.token.RefreshToken); rerr != nil {
+			return rerr
+		}
+		body, err = c.authedRequest(l, url)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("questrade: error parsing response: %v", err)
+	}
+	return nil
+}
+
+func (c *Client) authedRequest(l *limiter, url string) ([]byte, error) {
+	headers := map[string]string{
+		"Authorization": fmt.Sprintf("Bearer %s", c.token.AccessToken),
+	}
+	body, _, err := c.doWithRetry(l, url, headers)
+	return body, err
+}
+
+// doWithRetry issues the request, honoring Retry-After and falling back to
+// exponential backoff on 429s that don't specify one.
+func (c *Client) doWithRetry(l *limiter, url string, headers map[string]string) ([]byte, int, error) {
+	var lastStatus int
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		l.wait()
+
+		body, status, retryAfter, err := c.rawRequest(url, headers)
+		if err != nil {
+			return nil, status, err
+		}
+		lastStatus = status
+
+		switch {
+		case status == http.StatusOK:
+			return body, status, nil
+		case status == http.StatusUnauthorized:
+			return nil, status, &AuthError{Err: fmt.Errorf("unauthorized")}
+		case status == http.StatusTooManyRequests:
+			time.Sleep(retryDelay(retryAfter, attempt))
+			continue
+		default:
+			return nil, status, &APIError{StatusCode: status, Body: string(body)}
+		}
+	}
+
+	return nil, lastStatus, &APIError{StatusCode: lastStatus, Body: "exceeded retries after 429"}
+}
+
+// retryDelay prefers a server-specified Retry-After, falling back to
+// exponential backoff (1s, 2s, 4s, ...) when none was given.
+func retryDelay(retryAfter time.Duration, attempt int) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	return time.Duration(1<<uint(attempt)) * time.Second
+}
+
+func (c *Client) rawRequest(url string, headers map[string]string) ([]byte, int, time.Duration, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, 0, 0, &TransportError{Err: err}
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, 0, &TransportError{Err: err}
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, res.StatusCode, 0, &TransportError{Err: err}
+	}
+
+	var retryAfter time.Duration
+	if ra := res.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil {
+			retryAfter = time.Duration(secs) * time.Second
+		}
+	}
+
+	return body, res.StatusCode, retryAfter, nil
+}
@@ -0,0 +1,43 @@
+package questrade
+
+import "fmt"
+
+// AuthError indicates the refresh/access token was rejected or could not be
+// renewed. Callers typically respond by re-running setup with a fresh
+// REFRESH_TOKEN.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("questrade: auth error: %v", e.Err)
+}
+
+func (e *AuthError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError wraps failures getting a request to/from Questrade at all
+// (DNS, connection reset, timeouts, ...), as opposed to an API-level failure.
+type TransportError struct {
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("questrade: transport error: %v", e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// APIError is returned when Questrade responds with a non-2xx status that
+// isn't an auth failure.
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("questrade: api error (status %d): %s", e.StatusCode, e.Body)
+}
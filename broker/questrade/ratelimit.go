@@ -0,0 +1,84 @@
+package questrade
+
+import (
+	"sync"
+	"time"
+)
+
+// Questrade documents separate per-second limits for account calls and
+// market-data calls, plus an hourly ceiling on market-data calls. These are
+// conservative defaults, fixed at compile time; callers cannot change them.
+const (
+	accountCallsPerSecond = 20
+	marketCallsPerSecond  = 20
+	marketCallsPerHour    = 15000
+)
+
+// tokenBucket is a simple goroutine-safe token bucket. take() blocks until a
+// token is available, refilling continuously based on elapsed time.
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	max      float64
+	perSec   float64
+	lastFill time.Time
+}
+
+func newTokenBucket(max float64, perSec float64) *tokenBucket {
+	return &tokenBucket{
+		tokens:   max,
+		max:      max,
+		perSec:   perSec,
+		lastFill: time.Now(),
+	}
+}
+
+func (b *tokenBucket) take() {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		elapsed := now.Sub(b.lastFill).Seconds()
+		b.lastFill = now
+		b.tokens += elapsed * b.perSec
+		if b.tokens > b.max {
+			b.tokens = b.max
+		}
+
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return
+		}
+
+		wait := time.Duration((1 - b.tokens) / b.perSec * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// limiter bundles the per-second and per-hour buckets that apply to a given
+// call class (accounts vs. market data).
+type limiter struct {
+	perSecond *tokenBucket
+	perHour   *tokenBucket
+}
+
+func newAccountLimiter() *limiter {
+	return &limiter{
+		perSecond: newTokenBucket(accountCallsPerSecond, accountCallsPerSecond),
+	}
+}
+
+func newMarketLimiter() *limiter {
+	return &limiter{
+		perSecond: newTokenBucket(marketCallsPerSecond, marketCallsPerSecond),
+		perHour:   newTokenBucket(marketCallsPerHour, float64(marketCallsPerHour)/3600),
+	}
+}
+
+func (l *limiter) wait() {
+	if l.perHour != nil {
+		l.perHour.take()
+	}
+	l.perSecond.take()
+}
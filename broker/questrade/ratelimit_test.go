@@ -0,0 +1,48 @@
+package questrade
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketAllowsBurstUpToMax(t *testing.T) {
+	b := newTokenBucket(2, 2)
+
+	start := time.Now()
+	b.take()
+	b.take()
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("expected the first max tokens to be taken without waiting, took %v", elapsed)
+	}
+}
+
+func TestTokenBucketBlocksOnceDrained(t *testing.T) {
+	b := newTokenBucket(1, 2) // 1 token max, refilling at 2/sec
+
+	b.take() // drains the only token
+
+	start := time.Now()
+	b.take()
+	elapsed := time.Since(start)
+	if elapsed < 300*time.Millisecond {
+		t.Fatalf("expected take() to wait for a refill (~500ms), only waited %v", elapsed)
+	}
+	if elapsed > 1500*time.Millisecond {
+		t.Fatalf("took too long to refill: %v", elapsed)
+	}
+}
+
+func TestLimiterWaitConsultsBothBuckets(t *testing.T) {
+	l := &limiter{
+		perSecond: newTokenBucket(1, 1000), // effectively unlimited
+		perHour:   newTokenBucket(1, 2),    // the binding constraint
+	}
+
+	l.wait() // drains the hourly bucket
+
+	start := time.Now()
+	l.wait()
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Fatalf("expected wait() to be gated by the slower (hourly) bucket, only waited %v", elapsed)
+	}
+}
@@ -4,81 +4,52 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/MrException/invtrak/broker"
+	csvbroker "github.com/MrException/invtrak/broker/csv"
+	"github.com/MrException/invtrak/broker/questrade"
+	"github.com/MrException/invtrak/pkg/indicators"
+	"github.com/MrException/invtrak/pkg/portfolio"
 	"github.com/boltdb/bolt"
 )
 
-type cliConfig struct {
-	command string
-}
-
-type authToken struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token"`
-	APIServer    string `json:"api_server"`
-}
-
-func (t authToken) String() string {
-	return prettyJSON(t)
-}
-
-type account struct {
-	Type              string `json:"type"`
-	Number            string `json:"number"`
-	Status            string `json:"status"`
-	IsPrimary         bool   `json:"isPrimary"`
-	IsBilling         bool   `json:"isBilling"`
-	ClientAccountType string `json:"clientAccountType"`
-}
-
-func (a account) String() string {
-	return prettyJSON(a)
-}
-
-type accountReq struct {
-	Accounts []account `json:"accounts"`
-	UserID   int       `json:"userId"`
-}
+// defaultBrokerType is used whenever --broker is left unset, so existing
+// setups that only know Questrade keep working unchanged.
+const defaultBrokerType = "questrade"
 
-type Activity struct {
-	TradeDate       string  `json:"tradeDate"`
-	TransactionDate string  `json:"transactionDate"`
-	SettlementDate  string  `json:"settlementDate"`
-	Action          string  `json:"action"`
-	Symbol          string  `json:"symbol"`
-	SymbolID        int     `json:"symbolId"`
-	Description     string  `json:"description"`
-	Currency        string  `json:"currency"`
-	Quantity        int     `json:"quantity"`
-	Price           float64 `json:"price"`
-	GrossAmount     float64 `json:"grossAmount"`
-	Commission      float64 `json:"commission"`
-	NetAmount       float64 `json:"netAmount"`
-	Type            string  `json:"type"`
-}
-
-func (a Activity) String() string {
-	return prettyJSON(a)
-}
-
-type ActivitiesReq struct {
-	Activities []Activity `json:"activities"`
+type cliConfig struct {
+	command    string
+	history    time.Duration
+	fullResync bool
+	addr       string
+	symbol     string
+	interval   string
+	indicators string
+	chartOut   string
+	broker     string
+	csvConfig  string
 }
 
 var conf *cliConfig
 var db *bolt.DB
-var token *authToken
+var activeBroker broker.Broker
 
 func init() {
 	conf = &cliConfig{}
-	flag.StringVar(&conf.command, "command", "list-accounts", "Command to run: init, list-accounts, activities")
+	flag.StringVar(&conf.command, "command", "list-accounts", "Command to run: setup, list-accounts, refresh-activities, list-activities, positions, tax-report, add-corp-action, serve, create-access-token, chart")
+	flag.DurationVar(&conf.history, "history", defaultHistory, "how far back to sync activities on the first run for an account")
+	flag.BoolVar(&conf.fullResync, "full-resync", false, "ignore any saved sync watermark and re-walk the full --history window")
+	flag.StringVar(&conf.addr, "addr", ":8080", "address for the serve command to listen on")
+	flag.StringVar(&conf.symbol, "symbol", "", "symbol ID for the chart command")
+	flag.StringVar(&conf.interval, "interval", "OneDay", "candle interval for the chart command, e.g. OneMinute..OneMonth")
+	flag.StringVar(&conf.indicators, "indicators", "", "comma-separated chart overlays, e.g. sma:20,ema:50,bb:20,2,rsi:14")
+	flag.StringVar(&conf.chartOut, "out", "", "chart output for the chart command: a path (format inferred from its extension) or format=svg|png|html to write the default tmp/<symbol>.<format> path")
+	flag.StringVar(&conf.broker, "broker", defaultBrokerType, "brokerage backend for setup/refresh-activities: questrade or csv")
+	flag.StringVar(&conf.csvConfig, "csv-config", "", "path to a broker/csv Config file (required when --broker csv)")
 }
 
 func main() {
@@ -89,7 +60,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	err = initToken()
+	err = initBroker()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -98,7 +69,7 @@ func main() {
 	case "setup":
 		err = setup()
 	case "list-accounts":
-		var accounts []account
+		var accounts []broker.Account
 		accounts, err = loadAccounts()
 		if err == nil {
 			log.Printf("Loaded accounts: %v", accounts)
@@ -121,16 +92,82 @@ func main() {
 			err = fmt.Errorf("list-activities command requires an accountID argument")
 			break
 		}
-		var activities []Activity
-		activities, err = loadActivities(arg)
+		var activities []broker.Activity
+		activities, err = loadActivities(arg, "all")
 		if err == nil {
 			if len(activities) == 0 {
 				log.Printf("No activities found for account %s. Try refresh-activites.", arg)
 			} else {
-				log.Printf("Activities for account %s: %s", arg, activities)
+				log.Printf("Activities for account %s: %s", arg, prettyJSON(activities))
 			}
 		}
 
+	case "positions":
+		arg := flag.Arg(0)
+		if len(arg) == 0 {
+			err = fmt.Errorf("positions command requires an accountID argument")
+			break
+		}
+		var summary portfolio.Summary
+		summary, err = loadPositions(arg)
+		if err == nil {
+			log.Printf("Positions for account %s: %s", arg, prettyJSON(summary))
+		}
+
+	case "tax-report":
+		arg := flag.Arg(0)
+		if len(arg) == 0 {
+			err = fmt.Errorf("tax-report command requires an accountID argument")
+			break
+		}
+		year := time.Now().Year()
+		if yearArg := flag.Arg(1); yearArg != "" {
+			year, err = strconv.Atoi(yearArg)
+			if err != nil {
+				err = fmt.Errorf("invalid year %q: %v", yearArg, err)
+				break
+			}
+		}
+		var gains []portfolio.RealizedGain
+		gains, err = loadRealizedGains(arg, year)
+		if err == nil {
+			log.Printf("Realized gains for account %s in %d: %s", arg, year, prettyJSON(gains))
+		}
+
+	case "add-corp-action":
+		arg := flag.Arg(0)
+		if len(arg) == 0 {
+			err = fmt.Errorf("add-corp-action command requires a path to a JSON corporate action file")
+			break
+		}
+		err = addCorpAction(arg)
+
+	case "serve":
+		err = serve(conf.addr)
+
+	case "create-access-token":
+		err = createAccessToken()
+
+	case "chart":
+		if conf.symbol == "" {
+			err = fmt.Errorf("chart command requires --symbol")
+			break
+		}
+		var specs []indicators.Spec
+		specs, err = indicators.ParseSpec(conf.indicators)
+		if err != nil {
+			break
+		}
+		var outPath, format string
+		outPath, format, err = resolveChartOut(conf.chartOut, conf.symbol)
+		if err != nil {
+			break
+		}
+		err = renderChart(conf.symbol, conf.interval, specs, outPath, format)
+		if err == nil {
+			log.Printf("Wrote chart for symbol %s to %s", conf.symbol, outPath)
+		}
+
 	default:
 		err = fmt.Errorf("invalid command: %s", conf.command)
 	}
@@ -143,38 +180,55 @@ func main() {
 	os.Exit(0)
 }
 
-func initToken() error {
-	err := loadToken()
-	if err != nil {
-		refreshTokenStr, found := os.LookupEnv("REFRESH_TOKEN")
-		if !found {
+// initBroker builds the Broker selected by --broker (Questrade by default),
+// then authenticates it.
+func initBroker() error {
+	switch conf.broker {
+	case "", defaultBrokerType:
+		store := &boltTokenStore{}
+
+		refreshTokenStr := os.Getenv("REFRESH_TOKEN")
+		if _, err := store.LoadToken(); err != nil && refreshTokenStr == "" {
 			return fmt.Errorf("no token saved in DB, and no REFRESH_TOKEN env var set")
 		}
-		err = requestToken(refreshTokenStr)
 
+		qclient, err := questrade.NewClient(store, refreshTokenStr)
 		if err != nil {
 			return err
 		}
-	} else {
-		err = requestToken(token.RefreshToken)
+		activeBroker = qclient
+
+	case "csv":
+		if conf.csvConfig == "" {
+			return fmt.Errorf("--csv-config is required when --broker csv")
+		}
+		cfg, err := csvbroker.LoadConfig(conf.csvConfig)
 		if err != nil {
 			return err
 		}
+		activeBroker = csvbroker.New(cfg)
+
+	default:
+		return fmt.Errorf("unknown broker %q", conf.broker)
 	}
-	return nil
+
+	return activeBroker.Authenticate()
 }
 
+// setup discovers accounts from the configured broker and saves them,
+// stamping each with the broker type that found it so refreshActivities
+// later knows which Broker to dispatch through.
 func setup() error {
-	accounts, err := requestAccounts()
+	accounts, err := activeBroker.ListAccounts()
 	if err != nil {
 		return err
 	}
 
-	err = saveAccounts(accounts)
-	if err != nil {
-		return err
+	for i := range accounts {
+		accounts[i].BrokerType = conf.broker
 	}
-	return nil
+
+	return saveAccounts(accounts)
 }
 
 func setupDB() error {
@@ -192,6 +246,10 @@ func setupDB() error {
 		if err != nil {
 			return fmt.Errorf("could not create ACCOUNTS bucket: %v", err)
 		}
+		_, err = tx.CreateBucketIfNotExists([]byte("SYNC"))
+		if err != nil {
+			return fmt.Errorf("could not create SYNC bucket: %v", err)
+		}
 		return nil
 	})
 	if err != nil {
@@ -201,103 +259,49 @@ func setupDB() error {
 	return nil
 }
 
-func saveToken() error {
-	log.Println("Saving Token.")
-	tokenBytes, err := json.Marshal(token)
-	if err != nil {
-		return fmt.Errorf("could not marshal entry json: %v", err)
-	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		err := tx.Bucket([]byte("ROOT")).Put([]byte("TOKEN"), tokenBytes)
-		if err != nil {
-			return fmt.Errorf("could not insert token: %v", err)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return fmt.Errorf("could not save token, %v", err)
-	}
-	return nil
-}
+// boltTokenStore persists the Questrade token in the ROOT bucket, so the
+// client only has to refresh it when it's actually close to expiry.
+type boltTokenStore struct{}
 
-func loadToken() error {
+func (boltTokenStore) LoadToken() (*questrade.Token, error) {
 	log.Println("Loading Token.")
+	token := &questrade.Token{}
 	err := db.View(func(tx *bolt.Tx) error {
 		tokenStr := tx.Bucket([]byte("ROOT")).Get([]byte("TOKEN"))
 		if tokenStr == nil {
 			return fmt.Errorf("no token found")
 		}
-		// log.Printf("Loaded token from db: %v", string(tokenStr))
-
-		token = &authToken{}
-		err := json.Unmarshal(tokenStr, token)
-		if err != nil {
-			return fmt.Errorf("could not unmarshal token: %v", err)
-		}
-
-		return nil
+		return json.Unmarshal(tokenStr, token)
 	})
-
 	if err != nil {
-		return fmt.Errorf("could not load token, %v", err)
+		return nil, fmt.Errorf("could not load token, %v", err)
 	}
-	return nil
+	return token, nil
 }
 
-func requestToken(refreshTokenStr string) error {
-	// todo: save the last use of the token, only do a request if needed using authToken.ExpiresIn
-	log.Println("Requesting new token.")
-	url := fmt.Sprintf("https://login.questrade.com/oauth2/token?grant_type=refresh_token&refresh_token=%s", refreshTokenStr)
-
-	body, err := doReq(url, false)
-	if err != nil {
-		return fmt.Errorf("error requesting token, %v", err)
-	}
-
-	token = &authToken{}
-	err = json.Unmarshal(body, token)
+func (boltTokenStore) SaveToken(token *questrade.Token) error {
+	log.Println("Saving Token.")
+	tokenBytes, err := json.Marshal(token)
 	if err != nil {
-		return fmt.Errorf("error parsing JSON: %s", err)
+		return fmt.Errorf("could not marshal entry json: %v", err)
 	}
-	// log.Printf("%+v\n", token)
-
-	err = saveToken()
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("ROOT")).Put([]byte("TOKEN"), tokenBytes)
+	})
 	if err != nil {
-		return fmt.Errorf("error saving token: %sn", err)
+		return fmt.Errorf("could not save token, %v", err)
 	}
-
 	return nil
 }
 
-func requestAccounts() (*accountReq, error) {
-	log.Printf("Requesting accounts.")
-	url := fmt.Sprintf("%sv1/accounts", token.APIServer)
-
-	body, err := doReq(url, true)
-	if err != nil {
-		return nil, fmt.Errorf("error requesting accounts, %v", err)
-	}
-
-	accounts := &accountReq{}
-	err = json.Unmarshal(body, accounts)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %s", err)
-	}
-	// log.Printf("%+v\n", accounts)
-
-	return accounts, nil
-}
-
-func saveAccounts(accounts *accountReq) error {
+func saveAccounts(accounts []broker.Account) error {
 	err := db.Update(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("ACCOUNTS"))
 		if bucket == nil {
 			return fmt.Errorf("couldn't get ACCOUNTS bucket")
 		}
 
-		for _, account := range accounts.Accounts {
+		for _, account := range accounts {
 			accountBytes, err := json.Marshal(account)
 			if err != nil {
 				return fmt.Errorf("could not marshal entry json: %v", err)
@@ -318,9 +322,9 @@ func saveAccounts(accounts *accountReq) error {
 	return nil
 }
 
-func loadAccounts() ([]account, error) {
+func loadAccounts() ([]broker.Account, error) {
 	log.Println("Loading Accounts.")
-	accounts := make([]account, 0)
+	accounts := make([]broker.Account, 0)
 	err := db.View(func(tx *bolt.Tx) error {
 		bk := tx.Bucket([]byte("ACCOUNTS"))
 		if bk == nil {
@@ -329,7 +333,7 @@ func loadAccounts() ([]account, error) {
 
 		c := bk.Cursor()
 		for k, v := c.First(); k != nil; k, v = c.Next() {
-			acc := &account{}
+			acc := &broker.Account{}
 			err := json.Unmarshal(v, acc)
 			if err != nil {
 				return fmt.Errorf("could not unmarshal account: %v", err)
@@ -347,155 +351,22 @@ func loadAccounts() ([]account, error) {
 	return accounts, nil
 }
 
-func refreshAllActivities() error {
+// findAccount looks up a single saved account by number, so callers can
+// check which broker it belongs to before dispatching an operation to it.
+func findAccount(accountID string) (broker.Account, error) {
 	accounts, err := loadAccounts()
 	if err != nil {
-		return err
+		return broker.Account{}, err
 	}
-
 	for _, account := range accounts {
-		err = refreshActivities(account.Number)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-
-}
-
-func refreshActivities(accountID string) error {
-	return requestActivities(accountID)
-}
-
-func requestActivities(accountID string) error {
-	log.Printf("Requesting Activities.")
-	// start with the most recent 30 days
-	startDate := time.Now().AddDate(0, 0, -30)
-	endDate := time.Now()
-
-	days := (365 * 2.25) / 30 // number of 30 day blocks in 2 1/4 years - go back to fall 2015
-	for i := 0; i <= int(days); i++ {
-		url := fmt.Sprintf("%sv1/accounts/%s/activities?startTime=%s&endTime=%s", token.APIServer, accountID, startDate.Format(time.RFC3339), endDate.Format(time.RFC3339))
-		res, err := doReq(url, true)
-		if err != nil {
-			return fmt.Errorf("error requesting accounts, %v", err)
-		}
-		_, err = saveActivities(res, accountID)
-		if err != nil {
-			return fmt.Errorf("error saving activities, %v", err)
-		}
-		// log.Printf("Response: %s\n", string(res))
-
-		startDate = startDate.AddDate(0, 0, -30)
-		endDate = endDate.AddDate(0, 0, -30)
-	}
-
-	// log.Printf("%+v\n", accounts)
-
-	return nil
-}
-
-func saveActivities(body []byte, accountID string) ([]Activity, error) {
-	log.Println("Saving Activities.")
-
-	activities := &ActivitiesReq{}
-	err := json.Unmarshal(body, activities)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing JSON: %s", err)
-	}
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bkName := fmt.Sprintf("ACTIVITIES-%s", accountID)
-		bk, err := tx.CreateBucketIfNotExists([]byte(bkName))
-		if err != nil {
-			return fmt.Errorf("couldn't get/create %s bucket, %v", bkName, err)
-		}
-
-		for _, activity := range activities.Activities {
-			log.Printf("JSON: %s", activity)
-			activityBytes, err := json.Marshal(activity)
-			if err != nil {
-				return fmt.Errorf("could not marshal entry json: %v", err)
-			}
-			err = bk.Put([]byte(activity.TradeDate), activityBytes)
-			if err != nil {
-				return fmt.Errorf("could not insert activity: %v", err)
-			}
+		if account.Number == accountID {
+			return account, nil
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("could not save activities, %v", err)
 	}
-	return activities.Activities, nil
-}
-
-func loadActivities(accountID string) ([]Activity, error) {
-	log.Println("Loading Activities.")
-	activities := make([]Activity, 0)
-	err := db.View(func(tx *bolt.Tx) error {
-		bkName := fmt.Sprintf("ACTIVITIES-%s", accountID)
-		bk := tx.Bucket([]byte(bkName))
-		if bk == nil {
-			return fmt.Errorf("couldn't get %s bucket", bkName)
-		}
-
-		c := bk.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			act := &Activity{}
-			err := json.Unmarshal(v, act)
-			if err != nil {
-				return fmt.Errorf("could not unmarshal activity: %v", err)
-			}
-
-			activities = append(activities, *act)
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		return nil, fmt.Errorf("could not load activities, %v", err)
-	}
-	log.Printf("Found %d activities.", len(activities))
-	return activities, nil
+	return broker.Account{}, fmt.Errorf("no saved account %s - run setup first", accountID)
 }
 
 func prettyJSON(obj interface{}) string {
 	out, _ := json.MarshalIndent(obj, "", "  ")
 	return string(out)
 }
-
-func doReq(url string, addAuth bool) ([]byte, error) {
-	log.Printf("Sending GET to %s", url)
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("err creating request, %v", err)
-	}
-
-	if addAuth {
-		auth := fmt.Sprintf("Bearer %s", token.AccessToken)
-		request.Header.Set("Authorization", auth)
-	}
-
-	client := &http.Client{}
-	res, err := client.Do(request)
-	if err != nil {
-		return nil, fmt.Errorf("error performing request, %v", err)
-	}
-	defer res.Body.Close()
-
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading body: %s", err)
-	}
-
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("error return code: %d", res.StatusCode)
-	}
-
-	return body, nil
-}
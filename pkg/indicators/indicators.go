@@ -0,0 +1,127 @@
+// Package indicators computes chart overlays (moving averages, Bollinger
+// bands, RSI) from a plain series of closing prices, so they can be
+// layered onto any chart renderer.
+package indicators
+
+import "math"
+
+// SMA returns the simple moving average over period, aligned to values.
+// Entries before the window fills are math.NaN().
+func SMA(values []float64, period int) []float64 {
+	out := make([]float64, len(values))
+	sum := 0.0
+	for i, v := range values {
+		sum += v
+		if i >= period {
+			sum -= values[i-period]
+		}
+		if i < period-1 {
+			out[i] = math.NaN()
+		} else {
+			out[i] = sum / float64(period)
+		}
+	}
+	return out
+}
+
+// EMA returns the exponential moving average over period, seeded with a
+// simple average of the first window.
+func EMA(values []float64, period int) []float64 {
+	out := make([]float64, len(values))
+	if period <= 0 {
+		return out
+	}
+
+	k := 2.0 / float64(period+1)
+	var prev float64
+	for i, v := range values {
+		switch {
+		case i < period-1:
+			out[i] = math.NaN()
+		case i == period-1:
+			sum := 0.0
+			for _, w := range values[:period] {
+				sum += w
+			}
+			prev = sum / float64(period)
+			out[i] = prev
+		default:
+			prev = v*k + prev*(1-k)
+			out[i] = prev
+		}
+	}
+	return out
+}
+
+// BollingerBands returns the middle SMA band plus upper/lower bands
+// numStdDev population standard deviations (divided by period) away from it.
+func BollingerBands(values []float64, period int, numStdDev float64) (upper, middle, lower []float64) {
+	middle = SMA(values, period)
+	upper = make([]float64, len(values))
+	lower = make([]float64, len(values))
+
+	for i := range values {
+		if i < period-1 {
+			upper[i] = math.NaN()
+			lower[i] = math.NaN()
+			continue
+		}
+
+		window := values[i-period+1 : i+1]
+		variance := 0.0
+		for _, w := range window {
+			variance += (w - middle[i]) * (w - middle[i])
+		}
+		stdDev := math.Sqrt(variance / float64(period))
+		upper[i] = middle[i] + numStdDev*stdDev
+		lower[i] = middle[i] - numStdDev*stdDev
+	}
+	return upper, middle, lower
+}
+
+// RSI returns the relative strength index over period using Wilder's
+// smoothing. Entries before the first full window are math.NaN().
+func RSI(values []float64, period int) []float64 {
+	out := make([]float64, len(values))
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	if period <= 0 || len(values) <= period {
+		return out
+	}
+
+	var gainSum, lossSum float64
+	for i := 1; i <= period; i++ {
+		change := values[i] - values[i-1]
+		if change > 0 {
+			gainSum += change
+		} else {
+			lossSum -= change
+		}
+	}
+	avgGain := gainSum / float64(period)
+	avgLoss := lossSum / float64(period)
+	out[period] = rsiFromAverages(avgGain, avgLoss)
+
+	for i := period + 1; i < len(values); i++ {
+		change := values[i] - values[i-1]
+		gain, loss := 0.0, 0.0
+		if change > 0 {
+			gain = change
+		} else {
+			loss = -change
+		}
+		avgGain = (avgGain*float64(period-1) + gain) / float64(period)
+		avgLoss = (avgLoss*float64(period-1) + loss) / float64(period)
+		out[i] = rsiFromAverages(avgGain, avgLoss)
+	}
+	return out
+}
+
+func rsiFromAverages(avgGain, avgLoss float64) float64 {
+	if avgLoss == 0 {
+		return 100
+	}
+	rs := avgGain / avgLoss
+	return 100 - (100 / (1 + rs))
+}
@@ -0,0 +1,83 @@
+package indicators
+
+import (
+	"math"
+	"testing"
+)
+
+func floatsEqual(a, b float64) bool {
+	if math.IsNaN(a) && math.IsNaN(b) {
+		return true
+	}
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSMA(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5}
+	got := SMA(values, 3)
+	want := []float64{math.NaN(), math.NaN(), 2, 3, 4}
+
+	for i := range want {
+		if !floatsEqual(got[i], want[i]) {
+			t.Errorf("SMA[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEMASeedsWithSMAThenSmooths(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5}
+	got := EMA(values, 3)
+
+	if !floatsEqual(got[0], math.NaN()) || !floatsEqual(got[1], math.NaN()) {
+		t.Fatalf("expected the first period-1 entries to be NaN, got %v", got[:2])
+	}
+	if !floatsEqual(got[2], 2) {
+		t.Errorf("expected EMA[2] to be seeded with the SMA of the first window (2), got %v", got[2])
+	}
+
+	k := 2.0 / 4.0
+	want3 := 4*k + got[2]*(1-k)
+	if !floatsEqual(got[3], want3) {
+		t.Errorf("EMA[3] = %v, want %v", got[3], want3)
+	}
+}
+
+func TestBollingerBandsStraddleSMA(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 4, 3, 2, 1}
+	upper, middle, lower := BollingerBands(values, 3, 2)
+
+	for i := range values {
+		if i < 2 {
+			if !math.IsNaN(upper[i]) || !math.IsNaN(lower[i]) {
+				t.Errorf("expected NaN bands before the window fills at i=%d", i)
+			}
+			continue
+		}
+		if upper[i] < middle[i] || lower[i] > middle[i] {
+			t.Errorf("at i=%d expected lower <= middle <= upper, got %v/%v/%v", i, lower[i], middle[i], upper[i])
+		}
+	}
+}
+
+func TestRSIAllGainsIsHundred(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7}
+	got := RSI(values, 5)
+
+	for i := 0; i < 5; i++ {
+		if !math.IsNaN(got[i]) {
+			t.Errorf("expected NaN before the window fills at i=%d, got %v", i, got[i])
+		}
+	}
+	if got[5] != 100 {
+		t.Errorf("expected RSI of 100 when every change is a gain, got %v", got[5])
+	}
+}
+
+func TestRSIAllLossesIsZero(t *testing.T) {
+	values := []float64{7, 6, 5, 4, 3, 2, 1}
+	got := RSI(values, 5)
+
+	if got[5] != 0 {
+		t.Errorf("expected RSI of 0 when every change is a loss, got %v", got[5])
+	}
+}
@@ -0,0 +1,55 @@
+package indicators
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Spec is one overlay request parsed from a --indicators string, e.g.
+// "sma:20" or "bb:20,2".
+type Spec struct {
+	Name   string
+	Params []float64
+}
+
+// ParseSpec parses a comma-separated overlay list such as
+// "sma:20,ema:50,bb:20,2,rsi:14" into individual Specs. A token containing
+// ":" starts a new indicator; bare numeric tokens that follow are additional
+// params for the indicator most recently started (this is what lets
+// "bb:20,2" carry two params despite commas also separating indicators).
+func ParseSpec(s string) ([]Spec, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var specs []Spec
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+
+		if idx := strings.Index(token, ":"); idx >= 0 {
+			name, rest := token[:idx], token[idx+1:]
+			val, err := strconv.ParseFloat(rest, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid indicator param %q: %v", token, err)
+			}
+			specs = append(specs, Spec{Name: strings.ToLower(name), Params: []float64{val}})
+			continue
+		}
+
+		if len(specs) == 0 {
+			return nil, fmt.Errorf("indicator param %q has no preceding indicator", token)
+		}
+		val, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid indicator param %q: %v", token, err)
+		}
+		last := &specs[len(specs)-1]
+		last.Params = append(last.Params, val)
+	}
+
+	return specs, nil
+}
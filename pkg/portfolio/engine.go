@@ -0,0 +1,289 @@
+package portfolio
+
+import (
+	"sort"
+
+	"github.com/MrException/invtrak/broker"
+)
+
+// Compute replays activities in transaction-date order, applying any due
+// corporate actions along the way, and returns the resulting positions,
+// realized gains, income and cash balances.
+func Compute(activities []broker.Activity, corpActions []CorporateAction) Summary {
+	sorted := make([]broker.Activity, len(activities))
+	copy(sorted, activities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TransactionDate < sorted[j].TransactionDate
+	})
+
+	actionsBySymbol := make(map[int][]CorporateAction)
+	renameTargets := make(map[int]int)
+	for _, action := range corpActions {
+		actionsBySymbol[action.SymbolID] = append(actionsBySymbol[action.SymbolID], action)
+		if action.Type == "symbol-change" {
+			renameTargets[action.NewSymbolID] = action.SymbolID
+		}
+	}
+	for symbolID := range actionsBySymbol {
+		actions := actionsBySymbol[symbolID]
+		sort.Slice(actions, func(i, j int) bool {
+			return actions[i].EffectiveDate < actions[j].EffectiveDate
+		})
+		actionsBySymbol[symbolID] = actions
+	}
+	appliedCount := make(map[int]int)
+
+	books := make(map[int]*symbolBook)
+	realized := make([]RealizedGain, 0)
+	dividends := make(map[string]float64)
+	commissions := make(map[string]float64)
+	cash := make(map[string]float64)
+
+	for _, act := range sorted {
+		cash[act.Currency] += act.NetAmount
+		if act.Commission != 0 {
+			commissions[act.Currency] += act.Commission
+		}
+
+		if act.Type != "Trades" && act.Type != "Dividends" {
+			continue
+		}
+
+		resolveDueActions(books, actionsBySymbol, appliedCount, renameTargets, act.SymbolID, act.TransactionDate)
+
+		switch act.Type {
+		case "Dividends":
+			dividends[act.Currency] += act.NetAmount
+		case "Trades":
+			book := bookFor(books, act.Symbol, act.SymbolID)
+			switch act.Action {
+			case "Buy":
+				applyBuy(book, act)
+			case "Sell":
+				if gain, ok := applySell(book, act); ok {
+					realized = append(realized, gain)
+				}
+			}
+		}
+	}
+
+	// A symbol that isn't traded or paid a dividend again after its last
+	// corporate action (the common case for a split, and the only case for
+	// a symbol-change once the broker starts reporting under the new ID)
+	// never runs applyDueActions from inside the loop above. Catch those up
+	// before reporting positions and gains.
+	applyRemainingActions(books, actionsBySymbol, appliedCount)
+
+	return Summary{
+		Positions:      positions(books),
+		RealizedGains:  realized,
+		DividendIncome: dividends,
+		Commissions:    commissions,
+		Cash:           cashBalances(cash),
+	}
+}
+
+func bookFor(books map[int]*symbolBook, symbol string, symbolID int) *symbolBook {
+	book, ok := books[symbolID]
+	if !ok {
+		book = &symbolBook{symbol: symbol, symbolID: symbolID}
+		books[symbolID] = book
+	}
+	return book
+}
+
+func applyBuy(book *symbolBook, act broker.Activity) {
+	qty := abs(act.Quantity)
+	if qty == 0 {
+		return
+	}
+
+	cost := act.GrossAmount + act.Commission
+	if cost < 0 {
+		cost = -cost
+	}
+
+	book.fifo = append(book.fifo, lot{quantity: qty, unitCost: cost / float64(qty)})
+	book.acbShares += qty
+	book.acbCost += cost
+}
+
+func applySell(book *symbolBook, act broker.Activity) (RealizedGain, bool) {
+	qty := abs(act.Quantity)
+	if qty == 0 {
+		return RealizedGain{}, false
+	}
+
+	// Drain FIFO lots so AvgCostFIFO on the remaining position stays
+	// accurate, even though the realized gain itself is costed under ACB.
+	remaining := qty
+	for remaining > 0 && len(book.fifo) > 0 {
+		head := &book.fifo[0]
+		take := head.quantity
+		if take > remaining {
+			take = remaining
+		}
+		head.quantity -= take
+		remaining -= take
+		if head.quantity == 0 {
+			book.fifo = book.fifo[1:]
+		}
+	}
+
+	acbUnitCost := 0.0
+	if book.acbShares > 0 {
+		acbUnitCost = book.acbCost / float64(book.acbShares)
+	}
+	costBase := acbUnitCost * float64(qty)
+	book.acbCost -= costBase
+	book.acbShares -= qty
+
+	proceeds := act.GrossAmount - act.Commission
+	if proceeds < 0 {
+		proceeds = -proceeds
+	}
+
+	return RealizedGain{
+		Symbol:    act.Symbol,
+		SymbolID:  act.SymbolID,
+		TradeDate: act.TransactionDate,
+		Quantity:  qty,
+		Proceeds:  proceeds,
+		CostBase:  costBase,
+		Gain:      proceeds - costBase,
+	}, true
+}
+
+// resolveDueActions applies due actions for symbolID, first walking back
+// through any symbol-change chain that renamed an older SymbolID into this
+// one. Without that walk, an activity reported under the new ID would open
+// a fresh, empty book via bookFor before the rename that should have
+// carried the old book's FIFO lots and ACB forward ever ran.
+func resolveDueActions(books map[int]*symbolBook, actionsBySymbol map[int][]CorporateAction, appliedCount map[int]int, renameTargets map[int]int, symbolID int, asOf string) {
+	var ancestors []int
+	for cur := symbolID; ; {
+		parent, ok := renameTargets[cur]
+		if !ok {
+			break
+		}
+		ancestors = append(ancestors, parent)
+		cur = parent
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		applyDueActions(books, actionsBySymbol, appliedCount, ancestors[i], asOf)
+	}
+	applyDueActions(books, actionsBySymbol, appliedCount, symbolID, asOf)
+}
+
+// applyDueActions applies every corporate action for symbolID effective on
+// or before asOf that hasn't been applied yet.
+func applyDueActions(books map[int]*symbolBook, actionsBySymbol map[int][]CorporateAction, appliedCount map[int]int, symbolID int, asOf string) {
+	actions := actionsBySymbol[symbolID]
+	for appliedCount[symbolID] < len(actions) {
+		next := actions[appliedCount[symbolID]]
+		if next.EffectiveDate > asOf {
+			break
+		}
+
+		if book, ok := books[symbolID]; ok {
+			applyAction(books, book, next)
+		}
+		appliedCount[symbolID]++
+	}
+}
+
+// applyRemainingActions applies every corporate action still pending once
+// the activity loop is done, regardless of whether another activity on the
+// same symbol ever showed up to trigger applyDueActions. A symbol-change is
+// filed under its old SymbolID, so resolving it can make a book appear
+// under the new SymbolID with its own pending actions (e.g. a second
+// rename); repeat passes until one makes no further progress so those
+// chains resolve in whatever order the renames actually happened in.
+func applyRemainingActions(books map[int]*symbolBook, actionsBySymbol map[int][]CorporateAction, appliedCount map[int]int) {
+	for pass := 0; pass < len(actionsBySymbol)+1; pass++ {
+		progressed := false
+		for symbolID, actions := range actionsBySymbol {
+			for appliedCount[symbolID] < len(actions) {
+				book, ok := books[symbolID]
+				if !ok {
+					break
+				}
+				applyAction(books, book, actions[appliedCount[symbolID]])
+				appliedCount[symbolID]++
+				progressed = true
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+}
+
+func applyAction(books map[int]*symbolBook, book *symbolBook, action CorporateAction) {
+	switch action.Type {
+	case "split":
+		if action.Ratio <= 0 {
+			return
+		}
+		for i := range book.fifo {
+			book.fifo[i].quantity = int(float64(book.fifo[i].quantity) * action.Ratio)
+			book.fifo[i].unitCost /= action.Ratio
+		}
+		book.acbShares = int(float64(book.acbShares) * action.Ratio)
+		// Total ACB cost is unaffected by a split - only the per-share
+		// price changes.
+
+	case "symbol-change":
+		book.symbol = action.NewSymbol
+		book.symbolID = action.NewSymbolID
+		delete(books, action.SymbolID)
+		books[action.NewSymbolID] = book
+	}
+}
+
+func positions(books map[int]*symbolBook) []Position {
+	result := make([]Position, 0, len(books))
+	for _, book := range books {
+		if book.acbShares <= 0 {
+			continue
+		}
+		result = append(result, Position{
+			Symbol:      book.symbol,
+			SymbolID:    book.symbolID,
+			Quantity:    book.acbShares,
+			AvgCostFIFO: weightedAvgUnitCost(book.fifo),
+			ACB:         book.acbCost / float64(book.acbShares),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Symbol < result[j].Symbol })
+	return result
+}
+
+func weightedAvgUnitCost(lots []lot) float64 {
+	totalQty := 0
+	totalCost := 0.0
+	for _, l := range lots {
+		totalQty += l.quantity
+		totalCost += float64(l.quantity) * l.unitCost
+	}
+	if totalQty == 0 {
+		return 0
+	}
+	return totalCost / float64(totalQty)
+}
+
+func cashBalances(cash map[string]float64) []CashBalance {
+	result := make([]CashBalance, 0, len(cash))
+	for currency, amount := range cash {
+		result = append(result, CashBalance{Currency: currency, Amount: amount})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Currency < result[j].Currency })
+	return result
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,93 @@
+package portfolio
+
+import (
+	"testing"
+
+	"github.com/MrException/invtrak/broker"
+)
+
+func buy(date, symbol string, symbolID, qty int, price float64) broker.Activity {
+	gross := price * float64(qty)
+	return broker.Activity{
+		TransactionDate: date,
+		Action:          "Buy",
+		Symbol:          symbol,
+		SymbolID:        symbolID,
+		Currency:        "CAD",
+		Quantity:        qty,
+		Price:           price,
+		GrossAmount:     -gross,
+		NetAmount:       -gross,
+		Type:            "Trades",
+	}
+}
+
+func sell(date, symbol string, symbolID, qty int, price float64) broker.Activity {
+	gross := price * float64(qty)
+	return broker.Activity{
+		TransactionDate: date,
+		Action:          "Sell",
+		Symbol:          symbol,
+		SymbolID:        symbolID,
+		Currency:        "CAD",
+		Quantity:        -qty,
+		Price:           price,
+		GrossAmount:     gross,
+		NetAmount:       gross,
+		Type:            "Trades",
+	}
+}
+
+func TestComputeAppliesSplitWithNoLaterActivity(t *testing.T) {
+	activities := []broker.Activity{
+		buy("2024-01-10", "AAA", 1, 100, 10),
+	}
+	corpActions := []CorporateAction{
+		{SymbolID: 1, EffectiveDate: "2024-06-01", Type: "split", Ratio: 2},
+	}
+
+	summary := Compute(activities, corpActions)
+
+	if len(summary.Positions) != 1 {
+		t.Fatalf("expected 1 position, got %d", len(summary.Positions))
+	}
+	pos := summary.Positions[0]
+	if pos.Quantity != 200 {
+		t.Errorf("expected post-split quantity 200, got %d", pos.Quantity)
+	}
+	if pos.ACB != 5 {
+		t.Errorf("expected post-split ACB 5, got %v", pos.ACB)
+	}
+}
+
+func TestComputeAppliesSymbolChangeAfterBrokerSwitchesID(t *testing.T) {
+	activities := []broker.Activity{
+		buy("2024-01-10", "OLD", 1, 10, 100),
+		// The broker reports the renamed symbol under its new ID from here
+		// on; there's never another activity filed under the old ID.
+		sell("2024-08-01", "NEW", 2, 5, 120),
+	}
+	corpActions := []CorporateAction{
+		{SymbolID: 1, EffectiveDate: "2024-06-01", Type: "symbol-change", NewSymbolID: 2, NewSymbol: "NEW"},
+	}
+
+	summary := Compute(activities, corpActions)
+
+	if len(summary.RealizedGains) != 1 {
+		t.Fatalf("expected the sell under the new ID to be matched against the renamed book, got %d realized gains", len(summary.RealizedGains))
+	}
+	if got := summary.RealizedGains[0].CostBase; got != 500 {
+		t.Errorf("expected cost base carried over from the old book (5 * 100), got %v", got)
+	}
+
+	if len(summary.Positions) != 1 {
+		t.Fatalf("expected 1 remaining position, got %d", len(summary.Positions))
+	}
+	pos := summary.Positions[0]
+	if pos.Symbol != "NEW" || pos.SymbolID != 2 {
+		t.Errorf("expected remaining position under the new symbol/ID, got %+v", pos)
+	}
+	if pos.Quantity != 5 {
+		t.Errorf("expected 5 shares left, got %d", pos.Quantity)
+	}
+}
@@ -0,0 +1,72 @@
+// Package portfolio reconstructs holdings and realized/unrealized P&L from
+// an account's activity stream. It knows nothing about storage - callers
+// load activities and corporate actions from wherever they're kept (bolt,
+// in this repo) and pass them to Compute.
+package portfolio
+
+// CorporateAction describes a split or symbol change that the activity feed
+// doesn't represent cleanly on its own. Users populate these by hand since
+// Questrade doesn't expose a corporate-actions endpoint.
+type CorporateAction struct {
+	SymbolID      int     `json:"symbolId"`
+	EffectiveDate string  `json:"effectiveDate"`
+	Type          string  `json:"type"` // "split" or "symbol-change"
+	Ratio         float64 `json:"ratio,omitempty"`
+	NewSymbolID   int     `json:"newSymbolId,omitempty"`
+	NewSymbol     string  `json:"newSymbol,omitempty"`
+}
+
+// Position is a symbol's current holding, with cost basis under both
+// methods: FIFO (first lots bought are the first sold) and ACB (adjusted
+// cost base - a single running average, the method the CRA requires for
+// Canadian tax reporting).
+type Position struct {
+	Symbol      string  `json:"symbol"`
+	SymbolID    int     `json:"symbolId"`
+	Quantity    int     `json:"quantity"`
+	AvgCostFIFO float64 `json:"avgCostFifo"`
+	ACB         float64 `json:"acb"`
+}
+
+// RealizedGain is one sell's realized gain/loss, costed under ACB.
+type RealizedGain struct {
+	Symbol    string  `json:"symbol"`
+	SymbolID  int     `json:"symbolId"`
+	TradeDate string  `json:"tradeDate"`
+	Quantity  int     `json:"quantity"`
+	Proceeds  float64 `json:"proceeds"`
+	CostBase  float64 `json:"costBase"`
+	Gain      float64 `json:"gain"`
+}
+
+// CashBalance is the running cash total left over in one currency after
+// replaying every activity's NetAmount.
+type CashBalance struct {
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+}
+
+// Summary is the full result of replaying an account's activity stream.
+type Summary struct {
+	Positions      []Position         `json:"positions"`
+	RealizedGains  []RealizedGain     `json:"realizedGains"`
+	DividendIncome map[string]float64 `json:"dividendIncome"`
+	Commissions    map[string]float64 `json:"commissions"`
+	Cash           []CashBalance      `json:"cash"`
+}
+
+// lot is one FIFO purchase lot: a quantity bought at a unit cost.
+type lot struct {
+	quantity int
+	unitCost float64
+}
+
+// symbolBook holds both cost-basis views for a single symbol as activities
+// are replayed against it.
+type symbolBook struct {
+	symbol    string
+	symbolID  int
+	fifo      []lot
+	acbShares int
+	acbCost   float64
+}
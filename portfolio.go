@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/MrException/invtrak/pkg/portfolio"
+	"github.com/boltdb/bolt"
+)
+
+// loadPositions replays an account's full activity history, plus whatever
+// corporate actions the user has recorded, into a portfolio.Summary.
+func loadPositions(accountID string) (portfolio.Summary, error) {
+	activities, err := loadActivities(accountID, "all")
+	if err != nil {
+		return portfolio.Summary{}, err
+	}
+
+	corpActions, err := loadCorpActions()
+	if err != nil {
+		return portfolio.Summary{}, err
+	}
+
+	return portfolio.Compute(activities, corpActions), nil
+}
+
+// loadRealizedGains returns the realized gains from loadPositions whose
+// trade (transaction) date falls in the given calendar year, for tax
+// reporting.
+func loadRealizedGains(accountID string, year int) ([]portfolio.RealizedGain, error) {
+	summary, err := loadPositions(accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	gains := make([]portfolio.RealizedGain, 0)
+	for _, gain := range summary.RealizedGains {
+		tradeDate, err := time.Parse(time.RFC3339, gain.TradeDate)
+		if err != nil {
+			continue
+		}
+		if tradeDate.Year() == year {
+			gains = append(gains, gain)
+		}
+	}
+	return gains, nil
+}
+
+func loadCorpActions() ([]portfolio.CorporateAction, error) {
+	actions := make([]portfolio.CorporateAction, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("CORP_ACTIONS"))
+		if bk == nil {
+			return nil
+		}
+
+		c := bk.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			action := portfolio.CorporateAction{}
+			if err := json.Unmarshal(v, &action); err != nil {
+				return fmt.Errorf("could not unmarshal corporate action: %v", err)
+			}
+			actions = append(actions, action)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not load corporate actions, %v", err)
+	}
+	return actions, nil
+}
+
+// addCorpAction reads a single portfolio.CorporateAction as JSON from path
+// and upserts it into CORP_ACTIONS, keyed on symbol + effective date.
+func addCorpAction(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read corporate action file, %v", err)
+	}
+
+	action := portfolio.CorporateAction{}
+	if err := json.Unmarshal(body, &action); err != nil {
+		return fmt.Errorf("could not parse corporate action, %v", err)
+	}
+
+	actionBytes, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("could not marshal corporate action: %v", err)
+	}
+
+	key := fmt.Sprintf("%d|%s", action.SymbolID, action.EffectiveDate)
+	err = db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists([]byte("CORP_ACTIONS"))
+		if err != nil {
+			return fmt.Errorf("couldn't get/create CORP_ACTIONS bucket: %v", err)
+		}
+		return bk.Put([]byte(key), actionBytes)
+	})
+	if err != nil {
+		return fmt.Errorf("could not save corporate action, %v", err)
+	}
+	return nil
+}
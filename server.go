@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/MrException/invtrak/pkg/portfolio"
+	"github.com/boltdb/bolt"
+	"golang.org/x/crypto/sha3"
+)
+
+// apiError is the JSON envelope every error response from the server uses.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
+
+// serve runs the HTTP API server on addr until it receives SIGINT, then
+// shuts down gracefully.
+func serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/accounts", authed(handleAccounts))
+	mux.HandleFunc("/v1/accounts/", authed(handleAccountResource))
+	mux.HandleFunc("/v1/query/candles", authed(handleStatelessCandles))
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+
+	shutdownComplete := make(chan struct{})
+	go func() {
+		sigint := make(chan os.Signal, 1)
+		signal.Notify(sigint, os.Interrupt)
+		<-sigint
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("error shutting down server: %v", err)
+		}
+		close(shutdownComplete)
+	}()
+
+	log.Printf("Listening on %s", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("error running server, %v", err)
+	}
+
+	<-shutdownComplete
+	return nil
+}
+
+func handleAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("accounts only supports GET"))
+		return
+	}
+
+	accounts, err := loadAccounts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, accounts)
+}
+
+// handleAccountResource dispatches /v1/accounts/{id}/{resource} requests.
+func handleAccountResource(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/accounts/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+		return
+	}
+	accountID, resource := parts[0], parts[1]
+
+	switch resource {
+	case "activities":
+		handleActivities(w, r, accountID)
+	case "positions":
+		handlePositions(w, r, accountID)
+	case "pnl":
+		handlePnL(w, r, accountID)
+	case "refresh":
+		handleRefresh(w, r, accountID)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown account resource %q", resource))
+	}
+}
+
+func handleActivities(w http.ResponseWriter, r *http.Request, accountID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("activities only supports GET"))
+		return
+	}
+
+	activities, err := loadActivities(accountID, "all")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, activities)
+}
+
+// handlePositions returns an account's current holdings, replaying its full
+// activity history and any recorded corporate actions.
+func handlePositions(w http.ResponseWriter, r *http.Request, accountID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("positions only supports GET"))
+		return
+	}
+
+	summary, err := loadPositions(accountID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, summary.Positions)
+}
+
+// handlePnL returns an account's realized gains whose trade date falls
+// between from and to (RFC3339, both optional - an empty from means no lower
+// bound, an empty to means now).
+func handlePnL(w http.ResponseWriter, r *http.Request, accountID string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("pnl only supports GET"))
+		return
+	}
+
+	q := r.URL.Query()
+	from, err := parseTimeParam(q.Get("from"), time.Time{})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	summary, err := loadPositions(accountID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	gains := make([]portfolio.RealizedGain, 0)
+	for _, gain := range summary.RealizedGains {
+		tradeDate, err := time.Parse(time.RFC3339, gain.TradeDate)
+		if err != nil {
+			continue
+		}
+		if tradeDate.Before(from) || tradeDate.After(to) {
+			continue
+		}
+		gains = append(gains, gain)
+	}
+	writeJSON(w, http.StatusOK, gains)
+}
+
+// handleRefresh kicks off a background sync for accountID and returns
+// immediately; the caller polls /v1/accounts/{id}/activities for results.
+func handleRefresh(w http.ResponseWriter, r *http.Request, accountID string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("refresh only supports POST"))
+		return
+	}
+
+	go func() {
+		if err := refreshActivities(accountID); err != nil {
+			log.Printf("background refresh for account %s failed: %v", accountID, err)
+		}
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "refresh started"})
+}
+
+// handleStatelessCandles proxies a live candles call through the configured
+// broker without persisting anything, for ad-hoc queries against symbols
+// that aren't tracked in any account's activity history.
+func handleStatelessCandles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("candles only supports GET"))
+		return
+	}
+
+	q := r.URL.Query()
+	symbolID := q.Get("symbolId")
+	interval := q.Get("interval")
+	if symbolID == "" || interval == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("symbolId and interval are required"))
+		return
+	}
+
+	from, err := parseTimeParam(q.Get("from"), time.Now().AddDate(0, -1, 0))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	candles, err := activeBroker.FetchCandles(symbolID, interval, from, to)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, candles)
+}
+
+func parseTimeParam(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339: %v", v, err)
+	}
+	return t, nil
+}
+
+// authed requires a valid bearer token when ACCESS_TOKENS has any entries,
+// and otherwise passes requests straight through - so the server is usable
+// out of the box and auth is opt-in via create-access-token.
+func authed(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !accessTokensConfigured() {
+			next(w, r)
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" || !validAccessToken(token) {
+			writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid bearer token"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(h, prefix)
+}
+
+func accessTokensConfigured() bool {
+	configured := false
+	_ = db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("ACCESS_TOKENS"))
+		if bk == nil {
+			return nil
+		}
+		if k, _ := bk.Cursor().First(); k != nil {
+			configured = true
+		}
+		return nil
+	})
+	return configured
+}
+
+func validAccessToken(token string) bool {
+	sum := sha3.Sum256([]byte(token))
+	valid := false
+	_ = db.View(func(tx *bolt.Tx) error {
+		bk := tx.Bucket([]byte("ACCESS_TOKENS"))
+		if bk == nil {
+			return nil
+		}
+		if bk.Get(sum[:]) != nil {
+			valid = true
+		}
+		return nil
+	})
+	return valid
+}
+
+// createAccessToken mints a new bearer token, saves only its hash, and
+// prints the token once since it can't be recovered afterward.
+func createAccessToken() error {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return fmt.Errorf("error generating access token, %v", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	sum := sha3.Sum256([]byte(token))
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bk, err := tx.CreateBucketIfNotExists([]byte("ACCESS_TOKENS"))
+		if err != nil {
+			return fmt.Errorf("couldn't get/create ACCESS_TOKENS bucket: %v", err)
+		}
+		return bk.Put(sum[:], []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("could not save access token, %v", err)
+	}
+
+	log.Printf("Created access token (won't be shown again): %s", token)
+	return nil
+}
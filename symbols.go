@@ -3,108 +3,541 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
+	"html/template"
+	"image/color"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/MrException/invtrak/broker"
+	"github.com/MrException/invtrak/pkg/indicators"
 	"gonum.org/v1/plot"
-	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/plotter"
 	"gonum.org/v1/plot/vg"
+	"gonum.org/v1/plot/vg/draw"
+	"gonum.org/v1/plot/vg/vgimg"
+	"gonum.org/v1/plot/vg/vgsvg"
 )
 
-type Candle struct {
-	Start  string  `json:"start"`
-	End    string  `json:"end"`
-	Low    float64 `json:"low"`
-	High   float64 `json:"high"`
-	Open   float64 `json:"open"`
-	Close  float64 `json:"close"`
-	Volume int     `json:"volume"`
+// chartHistory bounds how much candle history the chart command requests -
+// enough to make the overlays in computeOverlays meaningful.
+const chartHistory = time.Duration(821) * 24 * time.Hour
+
+// defaultChartFormat is used when --out is left blank or given as a bare
+// "format=..." with no path.
+const defaultChartFormat = "svg"
+
+// chartHistoryByInterval caps how far back the chart command requests
+// candles for sub-daily intervals; requesting chartHistory's full ~2.25
+// years in a single call is far more than a real brokerage API will serve
+// for minute/hour bars. Intervals not listed here (OneDay and coarser) fall
+// back to chartHistory unchanged.
+var chartHistoryByInterval = map[string]time.Duration{
+	"OneMinute":      24 * time.Hour,
+	"TwoMinutes":     2 * 24 * time.Hour,
+	"ThreeMinutes":   3 * 24 * time.Hour,
+	"FourMinutes":    4 * 24 * time.Hour,
+	"FiveMinutes":    5 * 24 * time.Hour,
+	"TenMinutes":     10 * 24 * time.Hour,
+	"FifteenMinutes": 15 * 24 * time.Hour,
+	"TwentyMinutes":  20 * 24 * time.Hour,
+	"HalfHour":       30 * 24 * time.Hour,
+	"OneHour":        60 * 24 * time.Hour,
+	"TwoHours":       90 * 24 * time.Hour,
+	"FourHours":      180 * 24 * time.Hour,
 }
 
-func (c Candle) String() string {
-	return prettyJSON(c)
+// chartHistoryFor returns how far back to request candles for interval.
+func chartHistoryFor(interval string) time.Duration {
+	if d, ok := chartHistoryByInterval[interval]; ok {
+		return d
+	}
+	return chartHistory
 }
 
-type Candles struct {
-	Candles []Candle `json:"candles"`
+// resolveChartOut turns the chart command's single --out flag into a path
+// and a format, per the flag's documented syntax: a plain path infers its
+// format from the file extension, "format=svg|png|html" writes the default
+// tmp/<symbol>.<format> path in that format, and an empty value defaults to
+// defaultChartFormat.
+func resolveChartOut(out, symbol string) (path, format string, err error) {
+	if out == "" {
+		return fmt.Sprintf("tmp/%s.%s", symbol, defaultChartFormat), defaultChartFormat, nil
+	}
+
+	if strings.HasPrefix(out, "format=") {
+		format = strings.TrimPrefix(out, "format=")
+		if !validChartFormat(format) {
+			return "", "", fmt.Errorf("unknown chart format %q, expected format=svg, format=png or format=html", format)
+		}
+		return fmt.Sprintf("tmp/%s.%s", symbol, format), format, nil
+	}
+
+	format = strings.TrimPrefix(filepath.Ext(out), ".")
+	if !validChartFormat(format) {
+		return "", "", fmt.Errorf("chart output %q has unrecognized extension %q, expected .svg, .png or .html", out, filepath.Ext(out))
+	}
+	return out, format, nil
 }
 
-func (c Candles) Len() int {
-	return len(c.Candles)
+func validChartFormat(format string) bool {
+	switch format {
+	case "svg", "png", "html":
+		return true
+	default:
+		return false
+	}
+}
+
+// overlaySeries is one computed indicator line, aligned to the candle
+// series it was computed from (leading entries may be math.NaN()).
+type overlaySeries struct {
+	name   string
+	values []float64
 }
 
-func (list Candles) XY(i int) (float64, float64) {
-	c := list.Candles[i]
-	X, err := c.dayFloat()
+// renderChart requests candles for symbolID/interval, computes the
+// requested indicator overlays, and writes the chart to outPath in format
+// ("svg", "png", or "html").
+func renderChart(symbolID, interval string, specs []indicators.Spec, outPath, format string) error {
+	end := time.Now()
+	start := end.Add(-chartHistoryFor(interval))
+
+	candles, err := activeBroker.FetchCandles(symbolID, interval, start, end)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("error requesting candles, %v", err)
+	}
+	if len(candles.Candles) == 0 {
+		return fmt.Errorf("no candles returned for symbol %s", symbolID)
 	}
-	Y := c.Close
-	return X, Y
-}
 
-func (c Candle) dayFloat() (float64, error) {
-	d, err := time.Parse(time.RFC3339Nano, c.Start)
+	closes := make([]float64, len(candles.Candles))
+	for i, c := range candles.Candles {
+		closes[i] = c.Close
+	}
+
+	overlays, rsi, err := computeOverlays(closes, specs)
 	if err != nil {
-		return 0, fmt.Errorf("error parsing date for candle %s, %v", c.Start, err)
+		return err
+	}
+
+	if format == "html" {
+		return writeHTMLChart(symbolID, *candles, overlays, rsi, outPath)
 	}
-	// dStr := fmt.Sprintf("%d%02d%02d", d.Year(), d.Month(), d.Day())
-	// fmt.Println(dStr)
-	// dFlt, _ := strconv.ParseFloat(dStr, 64)
-	// return dFlt, nil
-	return float64(d.Unix()), nil
+	return writeImageChart(symbolID, *candles, overlays, rsi, outPath, format)
 }
 
-func requestCandles(symbolID string) error {
-	log.Printf("Requesting Candles.")
-	// start with the most recent 2.5 years
-	days := -1 * (365 * 2.5) // number of days in 2 1/2 years - go back to fall 2015
-	startDate := time.Now().AddDate(0, 0, int(days))
-	endDate := time.Now()
+func computeOverlays(closes []float64, specs []indicators.Spec) ([]overlaySeries, []float64, error) {
+	overlays := make([]overlaySeries, 0, len(specs))
+	var rsi []float64
 
-	url := fmt.Sprintf("%sv1/markets/candles/%s?interval=OneDay&startTime=%s&endTime=%s", token.APIServer, symbolID, startDate.Format(time.RFC3339), endDate.Format(time.RFC3339))
-	res, err := doReq(url, true)
-	if err != nil {
-		return fmt.Errorf("error requesting candles, %v", err)
+	for _, spec := range specs {
+		if len(spec.Params) < 1 {
+			return nil, nil, fmt.Errorf("indicator %q requires a period, e.g. %s:20", spec.Name, spec.Name)
+		}
+		period := int(spec.Params[0])
+
+		switch spec.Name {
+		case "sma":
+			overlays = append(overlays, overlaySeries{name: fmt.Sprintf("SMA(%d)", period), values: indicators.SMA(closes, period)})
+		case "ema":
+			overlays = append(overlays, overlaySeries{name: fmt.Sprintf("EMA(%d)", period), values: indicators.EMA(closes, period)})
+		case "bb":
+			numStdDev := 2.0
+			if len(spec.Params) > 1 {
+				numStdDev = spec.Params[1]
+			}
+			upper, middle, lower := indicators.BollingerBands(closes, period, numStdDev)
+			overlays = append(overlays,
+				overlaySeries{name: fmt.Sprintf("BB upper(%d)", period), values: upper},
+				overlaySeries{name: fmt.Sprintf("BB mid(%d)", period), values: middle},
+				overlaySeries{name: fmt.Sprintf("BB lower(%d)", period), values: lower},
+			)
+		case "rsi":
+			rsi = indicators.RSI(closes, period)
+		default:
+			return nil, nil, fmt.Errorf("unknown indicator %q", spec.Name)
+		}
 	}
 
-	data := &Candles{}
-	err = json.Unmarshal(res, data)
+	return overlays, rsi, nil
+}
+
+func candleX(c broker.Candle) (float64, error) {
+	t, err := time.Parse(time.RFC3339Nano, c.Start)
 	if err != nil {
-		return fmt.Errorf("error parsing JSON: %s", err)
+		return 0, fmt.Errorf("error parsing candle date %q: %v", c.Start, err)
 	}
+	return float64(t.Unix()), nil
+}
 
-	// for i, candle := range data.Candles {
-	// log.Printf("Candle %s: %s", string(i), candle)
-	// }
+// ohlcPlotter draws an OHLC candlestick for each candle: a wick spanning
+// low-high and a body spanning open-close, colored by direction.
+type ohlcPlotter struct {
+	candles broker.Candles
+}
 
-	drawPlot(symbolID, *data)
+func (o ohlcPlotter) Plot(c draw.Canvas, p *plot.Plot) {
+	trX, trY := p.Transforms(&c)
+	halfWidth := c.Size().X / vg.Length(len(o.candles.Candles)) * 0.35
 
-	// log.Printf("%+v\n", accounts)
+	for _, candle := range o.candles.Candles {
+		x, err := candleX(candle)
+		if err != nil {
+			continue
+		}
 
-	return nil
+		col := color.RGBA{R: 214, G: 69, B: 65, A: 255}
+		if candle.Close >= candle.Open {
+			col = color.RGBA{R: 38, G: 166, B: 91, A: 255}
+		}
+
+		xPix := trX(x)
+		c.StrokeLine2(draw.LineStyle{Color: col, Width: vg.Points(1)}, xPix, trY(candle.Low), xPix, trY(candle.High))
+
+		top, bottom := trY(candle.Open), trY(candle.Close)
+		if bottom > top {
+			top, bottom = bottom, top
+		}
+		c.FillPolygon(col, []vg.Point{
+			{X: xPix - halfWidth, Y: bottom},
+			{X: xPix + halfWidth, Y: bottom},
+			{X: xPix + halfWidth, Y: top},
+			{X: xPix - halfWidth, Y: top},
+		})
+	}
 }
 
-func drawPlot(symbolID string, candles Candles) error {
+func (o ohlcPlotter) DataRange() (xmin, xmax, ymin, ymax float64) {
+	xmin, ymin = math.Inf(1), math.Inf(1)
+	xmax, ymax = math.Inf(-1), math.Inf(-1)
+	for _, candle := range o.candles.Candles {
+		x, err := candleX(candle)
+		if err != nil {
+			continue
+		}
+		xmin, xmax = math.Min(xmin, x), math.Max(xmax, x)
+		ymin, ymax = math.Min(ymin, candle.Low), math.Max(ymax, candle.High)
+	}
+	return
+}
+
+// volumePlotter draws one bar per candle at its traded volume.
+type volumePlotter struct {
+	candles broker.Candles
+}
+
+func (v volumePlotter) Plot(c draw.Canvas, p *plot.Plot) {
+	trX, trY := p.Transforms(&c)
+	halfWidth := c.Size().X / vg.Length(len(v.candles.Candles)) * 0.35
+	base := trY(0)
+
+	for _, candle := range v.candles.Candles {
+		x, err := candleX(candle)
+		if err != nil {
+			continue
+		}
+		xPix := trX(x)
+		top := trY(float64(candle.Volume))
+		c.FillPolygon(color.Gray{Y: 140}, []vg.Point{
+			{X: xPix - halfWidth, Y: base},
+			{X: xPix + halfWidth, Y: base},
+			{X: xPix + halfWidth, Y: top},
+			{X: xPix - halfWidth, Y: top},
+		})
+	}
+}
+
+func (v volumePlotter) DataRange() (xmin, xmax, ymin, ymax float64) {
+	xmin, xmax = math.Inf(1), math.Inf(-1)
+	ymin, ymax = 0, math.Inf(-1)
+	for _, candle := range v.candles.Candles {
+		x, err := candleX(candle)
+		if err != nil {
+			continue
+		}
+		xmin, xmax = math.Min(xmin, x), math.Max(xmax, x)
+		ymax = math.Max(ymax, float64(candle.Volume))
+	}
+	return
+}
+
+func buildOverlayLine(candles broker.Candles, values []float64) (*plotter.Line, error) {
+	pts := make(plotter.XYs, 0, len(values))
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		x, err := candleX(candles.Candles[i])
+		if err != nil {
+			return nil, err
+		}
+		pts = append(pts, plotter.XY{X: x, Y: v})
+	}
+	return plotter.NewLine(pts)
+}
+
+var overlayColors = []color.Color{
+	color.RGBA{R: 41, G: 98, B: 255, A: 255},
+	color.RGBA{R: 255, G: 152, B: 0, A: 255},
+	color.RGBA{R: 156, G: 39, B: 176, A: 255},
+}
+
+func buildPricePlot(symbol string, candles broker.Candles, overlays []overlaySeries) (*plot.Plot, error) {
 	p, err := plot.New()
 	if err != nil {
-		return fmt.Errorf("error constructing plot, %v", err)
+		return nil, fmt.Errorf("error constructing price plot, %v", err)
 	}
+	p.Title.Text = symbol
+	p.Y.Label.Text = "Price"
+	p.X.Tick.Marker = plot.TimeTicks{}
+	p.Add(ohlcPlotter{candles: candles})
+
+	for i, overlay := range overlays {
+		line, err := buildOverlayLine(candles, overlay.values)
+		if err != nil {
+			return nil, err
+		}
+		line.Color = overlayColors[i%len(overlayColors)]
+		p.Add(line)
+		p.Legend.Add(overlay.name, line)
+	}
+
+	return p, nil
+}
 
+func buildVolumePlot(candles broker.Candles) (*plot.Plot, error) {
+	p, err := plot.New()
+	if err != nil {
+		return nil, fmt.Errorf("error constructing volume plot, %v", err)
+	}
+	p.Y.Label.Text = "Volume"
 	p.X.Tick.Marker = plot.TimeTicks{}
+	p.Add(volumePlotter{candles: candles})
+	return p, nil
+}
 
-	p.Title.Text = "Thing"
-	p.X.Label.Text = "Date"
-	p.Y.Label.Text = "Price"
+func buildRSIPlot(candles broker.Candles, rsi []float64) (*plot.Plot, error) {
+	p, err := plot.New()
+	if err != nil {
+		return nil, fmt.Errorf("error constructing rsi plot, %v", err)
+	}
+	p.Y.Label.Text = "RSI"
+	p.X.Tick.Marker = plot.TimeTicks{}
+	p.Y.Min, p.Y.Max = 0, 100
 
-	err = plotutil.AddLinePoints(p, symbolID, candles)
+	line, err := buildOverlayLine(candles, rsi)
 	if err != nil {
-		return fmt.Errorf("error plotting points, %v", err)
+		return nil, err
+	}
+	p.Add(line)
+	return p, nil
+}
+
+// writeImageChart lays out the price, volume, and (if requested) RSI panes
+// top to bottom on one canvas and writes it to outPath as svg or png.
+func writeImageChart(symbol string, candles broker.Candles, overlays []overlaySeries, rsi []float64, outPath, format string) error {
+	const width, height = 14 * vg.Inch, 10 * vg.Inch
+
+	var canvas vg.CanvasWriterTo
+	switch format {
+	case "svg":
+		canvas = vgsvg.New(width, height)
+	case "png":
+		canvas = vgimg.PngCanvas{Canvas: vgimg.New(width, height)}
+	default:
+		return fmt.Errorf("unknown chart format %q, expected svg, png or html", format)
 	}
 
-	if err := p.Save(10*vg.Inch, 10*vg.Inch, "tmp/plot.svg"); err != nil {
-		return fmt.Errorf("error creating the plot image, %v", err)
+	dc := draw.New(canvas)
+	total := dc.Max.Y - dc.Min.Y
+
+	rsiFrac, volFrac := 0.0, 0.2
+	if len(rsi) > 0 {
+		rsiFrac = 0.2
 	}
 
+	rsiTop := dc.Min.Y + total*vg.Length(rsiFrac)
+	volTop := rsiTop + total*vg.Length(volFrac)
+
+	volCanvas := draw.Canvas{Canvas: dc.Canvas, Rectangle: vg.Rectangle{Min: vg.Point{X: dc.Min.X, Y: rsiTop}, Max: vg.Point{X: dc.Max.X, Y: volTop}}}
+	priceCanvas := draw.Canvas{Canvas: dc.Canvas, Rectangle: vg.Rectangle{Min: vg.Point{X: dc.Min.X, Y: volTop}, Max: vg.Point{X: dc.Max.X, Y: dc.Max.Y}}}
+
+	pricePlot, err := buildPricePlot(symbol, candles, overlays)
+	if err != nil {
+		return err
+	}
+	pricePlot.Draw(priceCanvas)
+
+	volPlot, err := buildVolumePlot(candles)
+	if err != nil {
+		return err
+	}
+	volPlot.Draw(volCanvas)
+
+	if len(rsi) > 0 {
+		rsiCanvas := draw.Canvas{Canvas: dc.Canvas, Rectangle: vg.Rectangle{Min: vg.Point{X: dc.Min.X, Y: dc.Min.Y}, Max: vg.Point{X: dc.Max.X, Y: rsiTop}}}
+		rsiPlot, err := buildRSIPlot(candles, rsi)
+		if err != nil {
+			return err
+		}
+		rsiPlot.Draw(rsiCanvas)
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("error creating chart file, %v", err)
+	}
+	defer f.Close()
+
+	if _, err := canvas.WriteTo(f); err != nil {
+		return fmt.Errorf("error writing chart file, %v", err)
+	}
 	return nil
 }
+
+type htmlCandlePoint struct {
+	Time  int64   `json:"time"`
+	Open  float64 `json:"open"`
+	High  float64 `json:"high"`
+	Low   float64 `json:"low"`
+	Close float64 `json:"close"`
+}
+
+type htmlVolumePoint struct {
+	Time  int64  `json:"time"`
+	Value int    `json:"value"`
+	Color string `json:"color"`
+}
+
+type htmlLinePoint struct {
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+}
+
+type htmlOverlay struct {
+	Name   string          `json:"name"`
+	Points []htmlLinePoint `json:"points"`
+}
+
+type chartPageData struct {
+	Symbol       string
+	CandlesJSON  template.JS
+	VolumeJSON   template.JS
+	OverlaysJSON template.JS
+}
+
+// writeHTMLChart renders candles, volume and overlays as an HTML page that
+// loads lightweight-charts from a CDN and builds an interactive chart
+// client-side, so users can zoom and hover over the full history instead of
+// squinting at a static image.
+func writeHTMLChart(symbol string, candles broker.Candles, overlays []overlaySeries, rsi []float64, outPath string) error {
+	candlePoints := make([]htmlCandlePoint, 0, len(candles.Candles))
+	volumePoints := make([]htmlVolumePoint, 0, len(candles.Candles))
+	for _, c := range candles.Candles {
+		t, err := time.Parse(time.RFC3339Nano, c.Start)
+		if err != nil {
+			return fmt.Errorf("error parsing candle date %q: %v", c.Start, err)
+		}
+		col := "#d64541"
+		if c.Close >= c.Open {
+			col = "#26a65b"
+		}
+		candlePoints = append(candlePoints, htmlCandlePoint{Time: t.Unix(), Open: c.Open, High: c.High, Low: c.Low, Close: c.Close})
+		volumePoints = append(volumePoints, htmlVolumePoint{Time: t.Unix(), Value: c.Volume, Color: col})
+	}
+
+	htmlOverlays := make([]htmlOverlay, 0, len(overlays)+1)
+	for _, overlay := range overlays {
+		htmlOverlays = append(htmlOverlays, htmlOverlay{Name: overlay.name, Points: linePoints(candles, overlay.values)})
+	}
+	if len(rsi) > 0 {
+		htmlOverlays = append(htmlOverlays, htmlOverlay{Name: "RSI", Points: linePoints(candles, rsi)})
+	}
+
+	candlesJSON, err := json.Marshal(candlePoints)
+	if err != nil {
+		return fmt.Errorf("error encoding candles: %v", err)
+	}
+	volumeJSON, err := json.Marshal(volumePoints)
+	if err != nil {
+		return fmt.Errorf("error encoding volume: %v", err)
+	}
+	overlaysJSON, err := json.Marshal(htmlOverlays)
+	if err != nil {
+		return fmt.Errorf("error encoding overlays: %v", err)
+	}
+
+	f, err := os.Create(outPath)
+	if err != nil {
+		return fmt.Errorf("error creating chart file, %v", err)
+	}
+	defer f.Close()
+
+	return chartHTMLTemplate.Execute(f, chartPageData{
+		Symbol:       symbol,
+		CandlesJSON:  template.JS(candlesJSON),
+		VolumeJSON:   template.JS(volumeJSON),
+		OverlaysJSON: template.JS(overlaysJSON),
+	})
+}
+
+func linePoints(candles broker.Candles, values []float64) []htmlLinePoint {
+	points := make([]htmlLinePoint, 0, len(values))
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, candles.Candles[i].Start)
+		if err != nil {
+			continue
+		}
+		points = append(points, htmlLinePoint{Time: t.Unix(), Value: v})
+	}
+	return points
+}
+
+var chartHTMLTemplate = template.Must(template.New("chart").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Symbol}}</title>
+<script src="https://unpkg.com/lightweight-charts/dist/lightweight-charts.standalone.production.js"></script>
+<style>
+  html, body { margin: 0; padding: 0; background: #131722; }
+  #chart { width: 100vw; height: 100vh; }
+</style>
+</head>
+<body>
+<div id="chart"></div>
+<script>
+  const candles = {{.CandlesJSON}};
+  const volume = {{.VolumeJSON}};
+  const overlays = {{.OverlaysJSON}};
+
+  const chart = LightweightCharts.createChart(document.getElementById('chart'), {
+    layout: { backgroundColor: '#131722', textColor: '#d1d4dc' },
+    grid: { vertLines: { color: '#232632' }, horzLines: { color: '#232632' } },
+    timeScale: { timeVisible: true },
+  });
+
+  const candleSeries = chart.addCandlestickSeries();
+  candleSeries.setData(candles);
+
+  const volumeSeries = chart.addHistogramSeries({ priceScaleId: '', scaleMargins: { top: 0.8, bottom: 0 } });
+  volumeSeries.setData(volume);
+
+  overlays.forEach(function (overlay) {
+    const series = chart.addLineSeries({ title: overlay.name });
+    series.setData(overlay.points);
+  });
+
+  window.addEventListener('resize', function () {
+    chart.resize(window.innerWidth, window.innerHeight);
+  });
+</script>
+</body>
+</html>
+`))
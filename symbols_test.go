@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestResolveChartOutDefaultsToSVG(t *testing.T) {
+	path, format, err := resolveChartOut("", "AAPL")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format != "svg" {
+		t.Errorf("expected default format svg, got %q", format)
+	}
+	if path != "tmp/AAPL.svg" {
+		t.Errorf("expected default path tmp/AAPL.svg, got %q", path)
+	}
+}
+
+func TestResolveChartOutFormatOnly(t *testing.T) {
+	path, format, err := resolveChartOut("format=png", "AAPL")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format != "png" {
+		t.Errorf("expected format png, got %q", format)
+	}
+	if path != "tmp/AAPL.png" {
+		t.Errorf("expected default path tmp/AAPL.png, got %q", path)
+	}
+}
+
+func TestResolveChartOutInfersFormatFromPath(t *testing.T) {
+	path, format, err := resolveChartOut("charts/out.html", "AAPL")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if format != "html" {
+		t.Errorf("expected format html, got %q", format)
+	}
+	if path != "charts/out.html" {
+		t.Errorf("expected path charts/out.html unchanged, got %q", path)
+	}
+}
+
+func TestResolveChartOutRejectsUnknownFormat(t *testing.T) {
+	if _, _, err := resolveChartOut("format=pdf", "AAPL"); err == nil {
+		t.Error("expected an error for an unsupported format=pdf")
+	}
+	if _, _, err := resolveChartOut("out.pdf", "AAPL"); err == nil {
+		t.Error("expected an error for an unrecognized .pdf extension")
+	}
+}
+
+func TestChartHistoryForScalesDownSubDailyIntervals(t *testing.T) {
+	if got := chartHistoryFor("OneMinute"); got >= chartHistory {
+		t.Errorf("expected OneMinute history to be scaled below the %v default, got %v", chartHistory, got)
+	}
+	if got := chartHistoryFor("OneDay"); got != chartHistory {
+		t.Errorf("expected OneDay to keep the default history window, got %v", got)
+	}
+}